@@ -0,0 +1,66 @@
+package match
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// RegexPrefix marks a pattern as regular expression, patterns without it are globs
+const RegexPrefix = "re:"
+
+type pattern struct {
+	glob  string
+	regex *regexp.Regexp
+}
+
+func (p pattern) match(name string) bool {
+	if p.regex != nil {
+		return p.regex.MatchString(name)
+	}
+	matched, _ := path.Match(p.glob, name)
+	return matched
+}
+
+// Patterns is a list of glob or regular expression patterns, a name matches if any of them matches
+type Patterns []pattern
+
+// ParsePatterns parses comma separated patterns, e.g. "app-*,re:^team-[a-z]+$"
+func ParsePatterns(s string) (Patterns, error) {
+	var patterns Patterns
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if strings.HasPrefix(item, RegexPrefix) {
+			re, err := regexp.Compile(strings.TrimPrefix(item, RegexPrefix))
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression %s: %v", item, err)
+			}
+			patterns = append(patterns, pattern{regex: re})
+			continue
+		}
+		if _, err := path.Match(item, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob pattern %s: %v", item, err)
+		}
+		patterns = append(patterns, pattern{glob: item})
+	}
+	return patterns, nil
+}
+
+// Match returns true if any of the patterns matches name
+func (p Patterns) Match(name string) bool {
+	for _, pt := range p {
+		if pt.match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Empty returns true if there is no pattern
+func (p Patterns) Empty() bool {
+	return len(p) == 0
+}
@@ -0,0 +1,72 @@
+package match
+
+import "testing"
+
+func TestParsePatterns(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		wantErr bool
+		want    int
+	}{
+		{name: "empty", s: "", want: 0},
+		{name: "blank items", s: " , ,", want: 0},
+		{name: "globs and regex", s: "app-*, re:^team-[a-z]+$", want: 2},
+		{name: "invalid regex", s: "re:team-(", wantErr: true},
+		{name: "invalid regex among valid patterns", s: "app-*,re:[a-", wantErr: true},
+		{name: "negative lookahead is not supported by go regex", s: "re:^(?!kube-)", wantErr: true},
+		{name: "invalid glob", s: "app-[", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParsePatterns(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePatterns(%q) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(p) != tt.want {
+				t.Errorf("ParsePatterns(%q) got %d patterns, want %d", tt.s, len(p), tt.want)
+			}
+			if p.Empty() != (tt.want == 0) {
+				t.Errorf("ParsePatterns(%q).Empty() = %v", tt.s, p.Empty())
+			}
+		})
+	}
+}
+
+func TestPatternsMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns string
+		input    string
+		want     bool
+	}{
+		{name: "glob matches", patterns: "app-*", input: "app-web", want: true},
+		{name: "glob does not match", patterns: "app-*", input: "web-app", want: false},
+		{name: "regex matches", patterns: "re:^team-[a-z]+$", input: "team-blue", want: true},
+		{name: "regex does not match", patterns: "re:^team-[a-z]+$", input: "team-42", want: false},
+		{name: "regex is not anchored", patterns: "re:team", input: "my-team-1", want: true},
+		{name: "any pattern matches", patterns: "app-*,re:^team-", input: "team-x", want: true},
+		{name: "no pattern matches nothing", patterns: "", input: "app-web", want: false},
+		// there is no ! negation, names are excluded with the exclude patterns
+		{name: "leading bang is literal", patterns: "!app-*", input: "web", want: false},
+		{name: "leading bang matches literally", patterns: "!app-*", input: "!app-web", want: true},
+		{name: "bang in glob class is literal", patterns: "app-[!0-9]*", input: "app-web", want: false},
+		{name: "bang in glob class matches literally", patterns: "app-[!0-9]*", input: "app-1", want: true},
+		{name: "caret negates glob class", patterns: "app-[^0-9]*", input: "app-web", want: true},
+		{name: "caret negated glob class rejects", patterns: "app-[^0-9]*", input: "app-1", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParsePatterns(tt.patterns)
+			if err != nil {
+				t.Fatalf("ParsePatterns(%q) error = %v", tt.patterns, err)
+			}
+			if got := p.Match(tt.input); got != tt.want {
+				t.Errorf("ParsePatterns(%q).Match(%q) = %v, want %v", tt.patterns, tt.input, got, tt.want)
+			}
+		})
+	}
+}
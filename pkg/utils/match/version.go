@@ -0,0 +1,104 @@
+package match
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+type versionConstraint struct {
+	op      string
+	version *version.Version
+	// parts is the number of components given, "1.22" only compares major and minor
+	parts int
+}
+
+func (c versionConstraint) match(v *version.Version) bool {
+	cmp := compareComponents(v.Components(), c.version.Components(), c.parts)
+	switch c.op {
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	case "!=":
+		return cmp != 0
+	default:
+		return cmp == 0
+	}
+}
+
+func compareComponents(a, b []uint, parts int) int {
+	for i := 0; i < parts; i++ {
+		var x, y uint
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// VersionConstraints is a list of version constraints, a version matches if all of them match
+type VersionConstraints []versionConstraint
+
+// ParseVersionConstraints parses comma separated constraints, e.g. ">=1.20,<1.24" or "1.22",
+// supported operators are >=, <=, >, <, =, == and !=, no operator means equal
+func ParseVersionConstraints(s string) (VersionConstraints, error) {
+	var constraints VersionConstraints
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		op := ""
+		for _, o := range []string{">=", "<=", "!=", "==", ">", "<", "="} {
+			if strings.HasPrefix(item, o) {
+				op = o
+				break
+			}
+		}
+		raw := strings.TrimSpace(strings.TrimPrefix(item, op))
+		v, err := version.ParseGeneric(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version constraint %s: %v", item, err)
+		}
+		constraints = append(constraints, versionConstraint{
+			op:      op,
+			version: v,
+			parts:   len(strings.Split(strings.TrimPrefix(raw, "v"), ".")),
+		})
+	}
+	return constraints, nil
+}
+
+// Match returns true if v satisfies all the constraints, e.g. "v1.22.3+k3s1"
+func (c VersionConstraints) Match(v string) (bool, error) {
+	parsed, err := version.ParseGeneric(v)
+	if err != nil {
+		return false, err
+	}
+	for _, constraint := range c {
+		if !constraint.match(parsed) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Empty returns true if there is no constraint
+func (c VersionConstraints) Empty() bool {
+	return len(c) == 0
+}
@@ -0,0 +1,78 @@
+package match
+
+import "testing"
+
+func TestParseVersionConstraints(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		wantErr bool
+		want    int
+	}{
+		{name: "empty", s: "", want: 0},
+		{name: "range", s: ">=1.20, <1.24", want: 2},
+		{name: "no operator", s: "1.22", want: 1},
+		{name: "v prefix", s: "==v1.22.3", want: 1},
+		{name: "not equal", s: "!=1.21", want: 1},
+		{name: "operator only", s: ">=", wantErr: true},
+		{name: "not a version", s: "<abc", wantErr: true},
+		{name: "reversed operator", s: "=>1.20", wantErr: true},
+		{name: "bang without equal", s: "!1.21", wantErr: true},
+		{name: "double operator", s: ">=<1.20", wantErr: true},
+		{name: "malformed among valid constraints", s: ">=1.20,<1.x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseVersionConstraints(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseVersionConstraints(%q) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(c) != tt.want {
+				t.Errorf("ParseVersionConstraints(%q) got %d constraints, want %d", tt.s, len(c), tt.want)
+			}
+			if c.Empty() != (tt.want == 0) {
+				t.Errorf("ParseVersionConstraints(%q).Empty() = %v", tt.s, c.Empty())
+			}
+		})
+	}
+}
+
+func TestVersionConstraintsMatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		constraints string
+		version     string
+		want        bool
+		wantErr     bool
+	}{
+		{name: "no constraint", constraints: "", version: "v1.22.3", want: true},
+		{name: "equal on major and minor", constraints: "1.22", version: "v1.22.3+k3s1", want: true},
+		{name: "not equal on major and minor", constraints: "1.22", version: "v1.23.0", want: false},
+		{name: "equal on patch", constraints: "=1.22.3", version: "v1.22.4", want: false},
+		{name: "in range", constraints: ">=1.20,<1.24", version: "v1.23.1", want: true},
+		{name: "below range", constraints: ">=1.20,<1.24", version: "v1.19.9", want: false},
+		{name: "range end excluded", constraints: ">=1.20,<1.24", version: "v1.24.0", want: false},
+		{name: "negation excludes", constraints: "!=1.21", version: "v1.21.7", want: false},
+		{name: "negation keeps others", constraints: "!=1.21", version: "v1.22.0", want: true},
+		{name: "negation within range", constraints: ">=1.20,!=1.21", version: "v1.21.0", want: false},
+		{name: "malformed version", constraints: ">=1.20", version: "latest", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseVersionConstraints(tt.constraints)
+			if err != nil {
+				t.Fatalf("ParseVersionConstraints(%q) error = %v", tt.constraints, err)
+			}
+			got, err := c.Match(tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Match(%q) error = %v, wantErr %v", tt.version, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseVersionConstraints(%q).Match(%q) = %v, want %v", tt.constraints, tt.version, got, tt.want)
+			}
+		})
+	}
+}
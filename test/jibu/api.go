@@ -0,0 +1,102 @@
+package jibu
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	swagger "github.com/jibutech/backup-saas-client"
+	"github.com/stoneshi-yunify/jibutest/pkg/utils/random"
+)
+
+// randomName returns a resource name with a random suffix, e.g. {prefix}-abcd1234
+func randomName(prefix string) string {
+	return strings.ToLower(fmt.Sprintf("%s-%s", prefix, random.GetRandString(8)))
+}
+
+func newBackupPlan(tenant string, name string, cluster string, storage string, namespaces []string) swagger.V1alpha1BackupPlan {
+	return swagger.V1alpha1BackupPlan{
+		Metadata: &swagger.V1ObjectMeta{
+			Name: name,
+		},
+		Spec: &swagger.V1alpha1BackupPlanSpec{
+			ClusterName: cluster,
+			CopyMethod:  string(BackupCopyMethodFilesystem),
+			Desc:        name,
+			DisplayName: name,
+			Namespaces:  namespaces,
+			Policy: &swagger.V1alpha1BackupPolicy{
+				Retention: jobRetention,
+			},
+			StorageName: storage,
+			Tenant:      tenant,
+		},
+	}
+}
+
+func newBackupJob(tenant string, name string, planName string) swagger.V1alpha1BackupJob {
+	return swagger.V1alpha1BackupJob{
+		Metadata: &swagger.V1ObjectMeta{
+			Name: name,
+		},
+		Spec: &swagger.V1alpha1BackupJobSpec{
+			Action:      actionStartJob,
+			BackupName:  planName,
+			Desc:        name,
+			DisplayName: name,
+			Tenant:      tenant,
+		},
+	}
+}
+
+// newRestorePlan creates a restore plan object, namespace mappings are in the format of {backup-namespace}:{restore-namespace}
+func newRestorePlan(tenant string, name string, backupPlanName string, destCluster string, namespaceMappings []string) swagger.V1alpha1RestorePlan {
+	return swagger.V1alpha1RestorePlan{
+		Metadata: &swagger.V1ObjectMeta{
+			Name: name,
+		},
+		Spec: &swagger.V1alpha1RestorePlanSpec{
+			BackupName:        backupPlanName,
+			Desc:              name,
+			DestClusterName:   destCluster,
+			DisplayName:       name,
+			NamespaceMappings: namespaceMappings,
+			Tenant:            tenant,
+		},
+	}
+}
+
+func newRestoreJob(tenant string, name string, restorePlanName string, backupJobName string) swagger.V1alpha1RestoreJob {
+	return swagger.V1alpha1RestoreJob{
+		Metadata: &swagger.V1ObjectMeta{
+			Name: name,
+		},
+		Spec: &swagger.V1alpha1RestoreJobSpec{
+			Action:        actionStartJob,
+			BackupJobName: backupJobName,
+			Desc:          name,
+			DisplayName:   name,
+			RestoreName:   restorePlanName,
+			Tenant:        tenant,
+		},
+	}
+}
+
+// apiStatusCode returns the http status code of an api call, 0 if the server is not reached
+func apiStatusCode(resp *http.Response) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+// apiErrorBody returns the response body of a failed api call
+func apiErrorBody(err error) string {
+	if err == nil {
+		return ""
+	}
+	if swaggerErr, ok := err.(swagger.GenericSwaggerError); ok {
+		return string(swaggerErr.Body())
+	}
+	return err.Error()
+}
@@ -4,32 +4,48 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/robfig/cron/v3"
+	"k8s.io/apimachinery/pkg/api/resource"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 const (
-	resourcePickRetryLimit           = 10
-	jobRetention                     = 240
-	backupPlanReadyTimeout           = 5 * time.Minute
-	backupJobRepeatedCreationTimeout = 3 * time.Minute
-	backupJobFinishedTimeout         = 2 * time.Hour
-	restorePlanReadyTimeout          = 5 * time.Minute
-	restoreJobFinishedTimeout        = 2 * time.Hour
-	actionStartJob                   = "StartJob"
+	jobRetention           = 240
+	argConfigFileName      = "jibu-config"
+	soakSampleInterval     = time.Minute
+	progressLineInterval   = time.Minute
+	workloadReadyTimeout   = 10 * time.Minute
+	generationWriteTimeout = 3 * time.Minute
+	pvcBoundTimeout        = 5 * time.Minute
+	actionStartJob         = "StartJob"
 )
 
 // flags
 var (
+	argConfigFile             = flag.String(argConfigFileName, "", "yaml or json file of options keyed by the flag names without the jibu- prefix, e.g. api-endpoint, every option can also be set by a JIBU_* environment variable, e.g. JIBU_API_ENDPOINT, a flag wins over the environment, which wins over the file")
 	argTenant                 = flag.String("jibu-tenant", "1", "tenant id")
 	argJibuAPIEndpoint        = flag.String("jibu-api-endpoint", "http://localhost:31800", "jibu api endpoint")
 	argExcludeNamespaces      = flag.String("jibu-exclude-namespaces", "kube-system,kube-public,kube-node-lease,qiming-backend,backup-saas-system", "exclude namespaces for backup and restore, separated by comma")
+	argNamespaceInclude       = flag.String("jibu-namespace-include", "", "if set, only pick namespaces matching any of these patterns, separated by comma, globs by default, or regular expressions prefixed with re:")
+	argNamespaceExclude       = flag.String("jibu-namespace-exclude", "", "never pick namespaces matching any of these patterns, same syntax as jibu-namespace-include")
+	argNamespaceSelector      = flag.String("jibu-namespace-selector", "", "if set, only pick namespaces matching this label selector, e.g. env=test,tier!=db")
+	argNamespaceMinBoundPVCs  = flag.Int("jibu-namespace-min-bound-pvcs", 0, "only pick namespaces with at least this number of bound pvcs")
+	argNamespaceMinPods       = flag.Int("jibu-namespace-min-pods", 0, "only pick namespaces with at least this number of running pods")
 	argRestoreToSameNamespace = flag.Bool("jibu-restore-same-namespace", false, "restore uses same namespace as backup")
+	argRestoreDRMode          = flag.Bool("jibu-restore-dr-mode", false, "allow restoring into the backup namespace on the backup cluster as in disaster recovery, the namespace is restored in place")
 	argBackupRepeatEnabled    = flag.Bool("jibu-backup-repeat-enabled", false, "whether to create a repeted backupplan")
 	argBackupFrequency        = flag.String("jibu-backup-frequency", "*/3 * * * *", "the frequency(crontab string) to create backup jobs, defaults to every 3 minutes for faster testing, only effective when backup-repeat-enabled is set to true")
 	argBackupRepeatCheckNum   = flag.Int("jibu-bakcup-repeat-check-num", 3, "the number of times to check the creation of the repeated backupjob")
 	argBackupWithPV           = flag.Bool("jibu-backup-with-pv", true, "backup with pv")
+	argExcludedPVBehavior     = flag.String("jibu-excluded-pv-behavior", string(ExcludedPVBehaviorAny), "expected state of the restored PVCs when backup-with-pv is false: absent, empty(freshly provisioned) or any")
 	argBackupCopyMethod       = flag.String("jibu-backup-method", string(BackupCopyMethodFilesystem), "copy method of backup for PVs, defaults to filesystem(restic)")
 	argBackupNamespace        = flag.String("jibu-backup-namespace", "", "if set, backup specified namespace")
 	argRestoreNamespace       = flag.String("jibu-restore-namespace", "", "if set, restore to the specified namespace")
@@ -37,26 +53,275 @@ var (
 	argSkipRestore            = flag.Bool("jibu-skip-restore", false, "if set, skip restore test")
 	argBackupCluster          = flag.String("jibu-backup-cluster", "", "if set, use specified cluster for backup")
 	argRestoreCluster         = flag.String("jibu-restore-cluster", "", "if set, use specified cluster for restore")
+	argClusterPhase           = flag.String("jibu-cluster-phase", string(PhaseReady), "only pick clusters in this phase, empty for any phase")
+	argClusterSelector        = flag.String("jibu-cluster-selector", "", "if set, only pick clusters matching this label selector")
+	argClusterNamePattern     = flag.String("jibu-cluster-name-pattern", "", "if set, only pick clusters whose display name matches any of these patterns, globs by default, or regular expressions prefixed with re:")
+	argClusterK8sVersion      = flag.String("jibu-cluster-k8s-version", "", "if set, only pick clusters whose kubernetes version satisfies all these constraints, e.g. >=1.20,<1.24")
+	argStoragePhase           = flag.String("jibu-storage-phase", string(PhaseReady), "only pick storages in this phase, empty for any phase")
+	argStorageSelector        = flag.String("jibu-storage-selector", "", "if set, only pick storages matching this label selector")
+	argStorageNamePattern     = flag.String("jibu-storage-name-pattern", "", "if set, only pick storages whose display name matches any of these patterns")
+	argStorageType            = flag.String("jibu-storage-type", "", "if set, only pick storages of these types, separated by comma, e.g. s3,nfs")
+	argPickMode               = flag.String("jibu-pick-mode", string(PickModeRandom), "how to pick a cluster and a storage among the candidates: random or round-robin")
+	argPickStateFile          = flag.String("jibu-pick-state-file", filepath.Join(os.TempDir(), "jibutest-pick-state.json"), "file remembering the previous picks in round-robin mode")
 	argStorage                = flag.String("jibu-storage", "", "if set, use specified storage during test")
+	argPreflightEnabled       = flag.Bool("jibu-preflight-enabled", true, "check the api endpoint, tenant, clusters, controllers and storage before any plan is created")
+	argControllerNamespaces   = flag.String("jibu-controller-namespaces", "qiming-backend", "namespaces of the jibu controller pods on the clusters, separated by comma, checked by preflight")
+	argRequiredCRDs           = flag.String("jibu-required-crds", "", "CRDs that must exist on the clusters, separated by comma, checked by preflight, snapshot CRDs are always required by the snapshot copy method")
+	argNegativeTestEnabled    = flag.Bool("jibu-negative-test-enabled", false, "run the negative api contract tests, which send invalid plans and jobs and expect them to be rejected")
+	argUnreachableStorage     = flag.String("jibu-unreachable-storage", "", "storage whose backups fail, e.g. one with a wrong endpoint, used by the negative api contract tests to create a failed backup job, any storage of the tenant which isn't ready is used if not set")
+	argIsolationTenant        = flag.String("jibu-isolation-tenant", "", "if set, run the tenant isolation tests, which create plans and jobs in jibu-tenant and verify this tenant can't see, change or restore from them")
+	argQueryTestEnabled       = flag.Bool("jibu-query-test-enabled", false, "run the list query conformance tests, which check the server honors the sort, filter and paging options of the list endpoints")
+	argPaginationTestEnabled  = flag.Bool("jibu-pagination-test-enabled", false, "run the list pagination tests, which create more jobs than a page holds and walk the pages while new jobs are created")
+	argPlanUpdateTestEnabled  = flag.Bool("jibu-plan-update-test-enabled", false, "run the backup plan update tests, which edit live repeated plans and check the scheduler picks up the changes, takes about an hour")
+	argCascadeTestEnabled     = flag.Bool("jibu-cascade-test-enabled", false, "run the cascade deletion tests, which delete plans with existing jobs and check what happens to the jobs and their data")
+	argSoakDuration           = flag.Duration("jibu-soak-duration", 0, "if set, run the soak test for this long, e.g. 24h, it keeps a repeated plan firing at jibu-backup-frequency and restores random completed jobs periodically")
+	argSoakRestoreInterval    = flag.Duration("jibu-soak-restore-interval", time.Hour, "how often the soak test restores a random completed backup job")
+	argSoakErrorBudget        = flag.Int("jibu-soak-error-budget", 3, "the soak test fails once the failed backup jobs and restores exceed this number")
+	argSoakSummaryFile        = flag.String("jibu-soak-summary-file", "soak-summary.json", "file the rolling soak summary is written to")
+	argLoadPlans              = flag.Int("jibu-load-plans", 0, "if set, run the load test with this number of backup plans created at the same time")
+	argLoadNamespaces         = flag.Int("jibu-load-namespaces", 1, "number of namespaces created for the load test, the plans are spread across them")
+	argLoadJobsPerPlan        = flag.Int("jibu-load-jobs-per-plan", 1, "number of on-demand jobs started for each plan of the load test")
+	argLoadJobInterval        = flag.Duration("jibu-load-job-interval", 10*time.Second, "interval between starting two on-demand jobs of the load test, across all the plans")
+	argLoadConcurrency        = flag.Int("jibu-load-concurrency", 10, "max number of plans and jobs the load test creates at the same time")
+	argLoadMaxRunningJobs     = flag.Int("jibu-load-max-running-jobs", 100, "max number of on-demand jobs of the load test waited on at the same time, the next job is started once one of them finishes")
+	argLoadMaxErrorRate       = flag.Float64("jibu-load-max-error-rate", 0, "the load test fails if the ratio of failed api calls exceeds this, e.g. 0.05, failed or timed out jobs are reported but not counted")
+	argBenchPlanName          = flag.String("jibu-bench-plan-name", "", "backup plan the benchmarks run against, if not set, a new plan is created and kept for later runs")
+	argBenchSeedJobs          = flag.Int("jibu-bench-seed-jobs", 0, "before the benchmarks, create on-demand jobs until the benchmark plan has at least this number of jobs")
+	argWatchCRs               = flag.Bool("jibu-watch-crs", false, "watch the jibu custom resources in the clusters instead of polling the REST api every 5 seconds, the REST view is cross-checked when a wait ends, falls back to polling if the CRDs aren't reachable")
+	argCRGroup                = flag.String("jibu-cr-group", "ys.jibudata.com", "api group of the jibu custom resources, used by jibu-watch-crs")
+	argCRVersion              = flag.String("jibu-cr-version", "v1alpha1", "api version of the jibu custom resources, used by jibu-watch-crs")
+	argCRNamespace            = flag.String("jibu-cr-namespace", "qiming-backend", "namespace of the jibu custom resources, used by jibu-watch-crs")
+	argEventsFile             = flag.String("jibu-events-file", "", "if set, every phase or progress change of the plans and jobs is appended to this file as a json line")
+	argRedactFields           = flag.String("jibu-redact-fields", "", "names of extra fields and flags whose values are masked in logs, dumps and reports, separated by comma, kubeconfigs, storage credentials, tokens and auth headers are always masked")
+	argRedactPatterns         = newRepeatedFlag("jibu-redact-patterns", "regular expression whose matches are masked in logs, dumps and reports, repeat the flag for more of them, they are separated by newline in the env variable")
+	argLogLevel               = flag.String("jibu-log-level", levelInfo.String(), "minimum level of the log lines: debug, info, warn or error")
+	argLogFile                = flag.String("jibu-log-file", "", "if set, the log lines are also appended to this file as json lines with their fields")
+	argRunID                  = flag.String("jibu-run-id", "", "id added to every log line to tell the runs apart, random if not set")
+	argClusterKubeconfigs     = flag.String("jibu-cluster-kubeconfigs", "", "local kubeconfig files of the clusters, as cluster-id=path pairs separated by comma, used instead of fetching the kubeconfig through the api")
+	argClusterContexts        = flag.String("jibu-cluster-contexts", "", "contexts of the local kubeconfigs of the clusters, as cluster-id=context pairs separated by comma, a cluster without a kubeconfig file uses the default kubeconfig(KUBECONFIG or ~/.kube/config)")
+	argAPIKubeconfigAllowed   = flag.Bool("jibu-api-kubeconfig-allowed", true, "whether the kubeconfig of a cluster without a local one may be fetched through the api, which some tenants forbid")
 	argCleanUpOnEnd           = flag.Bool("jibu-clean-up-on-end", true, "clean up after test, including delete backup/restore jobs, delete restored namespace etc.")
 	argBackupPlanName         = flag.String("jibu-backup-plan-name", "", "backup plan name, if not set, will use backup-{timestamp}")
 	argBackupJobName          = flag.String("jibu-backup-job-name", "", "backup job name, if not set, will use {backup-plan-name}-{random-string}")
 	argRestorePlanName        = flag.String("jibu-restore-plan-name", "", "restore plan name, if not set, will use restore-{timestamp}")
 	argRestoreJobName         = flag.String("jibu-restore-job-name", "", "restore job name, if not set, will use {restore-plan-name}-{random-string}")
+	argGenerationFixture      = flag.Bool("jibu-generation-fixture-enabled", false, "deploy a statefulset into a new backup namespace which stamps a new data generation before each backup job, and verify the restored generation")
+	argCRDFixture             = flag.Bool("jibu-crd-fixture-enabled", false, "install a test CRD and create custom resources in the backup namespace, and verify them after restore")
+	argRestorePoint           = flag.Int("jibu-restore-point", 0, "index of the backup job to restore, sorted by creation time, negative index counts from the latest, e.g. -1 restores the latest job")
+	argWorkloadManifestDir    = flag.String("jibu-workload-manifest-dir", "", "if set, apply the manifests in this directory into a freshly created namespace and back it up, relative to test/jibu, e.g. testdata/workload")
+)
+
+// timeout flags, read through the functions in timeout.go which apply the scaling
+var (
+	argBackupPlanReadyTimeout   = flag.Duration("jibu-backup-plan-ready-timeout", 5*time.Minute, "how long a backup plan may take to become ready")
+	argRestorePlanReadyTimeout  = flag.Duration("jibu-restore-plan-ready-timeout", 5*time.Minute, "how long a restore plan may take to become ready")
+	argBackupJobCreationTimeout = flag.Duration("jibu-backup-job-creation-timeout", 3*time.Minute, "how long a repeated backup plan may take to create its next backup job")
+	argBackupJobTimeout         = flag.Duration("jibu-backup-job-timeout", 2*time.Hour, "how long a backup job may take to finish, before scaling by data size")
+	argRestoreJobTimeout        = flag.Duration("jibu-restore-job-timeout", 2*time.Hour, "how long a restore job may take to finish, before scaling by data size")
+	argPollInterval             = flag.Duration("jibu-poll-interval", 5*time.Second, "how often the REST api and the clusters are polled while waiting")
+	argTimeoutScale             = flag.Float64("jibu-timeout-scale", 1, "factor all the timeouts above are multiplied by, e.g. 2 for a slow environment")
+	argJobTimeoutPerGiB         = flag.Duration("jibu-job-timeout-per-gib", 0, "if set, the backup and restore job timeouts are extended by this for every GiB of backup data")
+	argDataSize                 = flag.String("jibu-data-size", "", "size of the backup data the job timeouts are scaled by, e.g. 50Gi, measured from the bound pvcs of the backup namespace if not set")
 )
 
 var ctx = context.Background()
 
-func validateFlags() error {
+var tenantPattern = regexp.MustCompile(`^[0-9]+$`)
+
+// repeatedFlag collects the values of a flag given several times, for items which may contain commas, like regexps
+type repeatedFlag []string
+
+func newRepeatedFlag(name string, usage string) *repeatedFlag {
+	r := &repeatedFlag{}
+	flag.Var(r, name, usage)
+	return r
+}
+
+func (r *repeatedFlag) String() string {
+	if r == nil || len(*r) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%q", []string(*r))
+}
+
+// Set appends the value, so that each occurrence of the flag adds one item
+func (r *repeatedFlag) Set(value string) error {
+	*r = append(*r, value)
+	return nil
+}
+
+// splitList splits a comma separated flag value, empty items are dropped
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// validateFlags checks the flags and their combinations, all the problems found are returned together in an aggregate
+func validateFlags() utilerrors.Aggregate {
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	check(parseFlags())
+
+	check(validateEndpoint(*argJibuAPIEndpoint))
+	check(validateTenant("jibu-tenant", *argTenant))
+	if *argIsolationTenant != "" {
+		check(validateTenant("jibu-isolation-tenant", *argIsolationTenant))
+		if *argIsolationTenant == *argTenant {
+			check(fmt.Errorf("jibu-isolation-tenant must differ from jibu-tenant %s", *argTenant))
+		}
+	}
+
+	// job names default to {plan-name}-{random-string}
+	planNameMaxLen := func(jobName string) int {
+		if jobName == "" {
+			return validation.DNS1123LabelMaxLength - 6
+		}
+		return validation.DNS1123LabelMaxLength
+	}
+	check(validateName("jibu-backup-plan-name", *argBackupPlanName, planNameMaxLen(*argBackupJobName)))
+	check(validateName("jibu-backup-job-name", *argBackupJobName, validation.DNS1123LabelMaxLength))
+	check(validateName("jibu-restore-plan-name", *argRestorePlanName, planNameMaxLen(*argRestoreJobName)))
+	check(validateName("jibu-restore-job-name", *argRestoreJobName, validation.DNS1123LabelMaxLength))
+	check(validateName("jibu-backup-namespace", *argBackupNamespace, validation.DNS1123LabelMaxLength))
+	check(validateName("jibu-restore-namespace", *argRestoreNamespace, validation.DNS1123LabelMaxLength))
+
+	if *argSkipBackup && *argBackupPlanName == "" {
+		check(fmt.Errorf("jibu-skip-backup needs jibu-backup-plan-name of an existing backup plan to restore from"))
+	}
+	if *argBackupRepeatEnabled && *argBackupRepeatCheckNum <= 0 {
+		check(fmt.Errorf("invalid backup repeat check num %d: must be positive when backup repeat is enabled", *argBackupRepeatCheckNum))
+	}
+	if *argRestoreToSameNamespace && *argRestoreNamespace != "" && *argBackupNamespace != "" && *argRestoreNamespace != *argBackupNamespace {
+		check(fmt.Errorf("jibu-restore-same-namespace contradicts jibu-restore-namespace %s, which differs from jibu-backup-namespace %s", *argRestoreNamespace, *argBackupNamespace))
+	}
+	sameNamespace := *argRestoreToSameNamespace || (*argRestoreNamespace != "" && *argRestoreNamespace == *argBackupNamespace)
+	// a picked cluster may turn out to be the other one, so only two different given clusters are safe
+	differentClusters := *argBackupCluster != "" && *argRestoreCluster != "" && *argBackupCluster != *argRestoreCluster
+	if sameNamespace && !differentClusters && !*argRestoreDRMode {
+		check(fmt.Errorf("restoring into the backup namespace needs different jibu-backup-cluster and jibu-restore-cluster, or jibu-restore-dr-mode"))
+	}
+
 	if *argBackupCopyMethod != string(BackupCopyMethodFilesystem) && *argBackupCopyMethod != string(BackupCopyMethodSnapshot) {
-		return fmt.Errorf("invalid backup copy method: %s", *argBackupCopyMethod)
+		check(fmt.Errorf("invalid backup copy method: %s", *argBackupCopyMethod))
+	}
+
+	_, err := newNamespaceFilterFromFlags()
+	check(err)
+	_, err = newClusterFilterFromFlags()
+	check(err)
+	_, err = newStorageFilterFromFlags()
+	check(err)
+	_, err = localKubeconfigs()
+	check(err)
+	_, err = parseRedactPatterns()
+	check(err)
+	minLogLevel, err = parseLogLevel(*argLogLevel)
+	check(err)
+	if *argPickMode != string(PickModeRandom) && *argPickMode != string(PickModeRoundRobin) {
+		check(fmt.Errorf("invalid pick mode: %s", *argPickMode))
 	}
 
-	if *argBackupRepeatEnabled {
+	switch ExcludedPVBehavior(*argExcludedPVBehavior) {
+	case ExcludedPVBehaviorAbsent, ExcludedPVBehaviorEmpty, ExcludedPVBehaviorAny:
+	default:
+		check(fmt.Errorf("invalid excluded pv behavior: %s", *argExcludedPVBehavior))
+	}
+
+	// the statefulset of the generation fixture recreates its missing pvcs right after restore
+	if *argGenerationFixture && !*argBackupWithPV && ExcludedPVBehavior(*argExcludedPVBehavior) == ExcludedPVBehaviorAbsent {
+		check(fmt.Errorf("excluded pv behavior %s can't be verified with the generation fixture", ExcludedPVBehaviorAbsent))
+	}
+
+	if *argBackupPlanReadyTimeout <= 0 || *argRestorePlanReadyTimeout <= 0 || *argBackupJobCreationTimeout <= 0 ||
+		*argBackupJobTimeout <= 0 || *argRestoreJobTimeout <= 0 || *argPollInterval <= 0 {
+		check(fmt.Errorf("timeouts and poll interval must be positive"))
+	}
+	if *argTimeoutScale <= 0 || *argJobTimeoutPerGiB < 0 {
+		check(fmt.Errorf("invalid timeout scaling: scale %v, per GiB %v", *argTimeoutScale, *argJobTimeoutPerGiB))
+	}
+	if *argDataSize != "" {
+		if _, err := resource.ParseQuantity(*argDataSize); err != nil {
+			check(fmt.Errorf("invalid data size %s: %v", *argDataSize, err))
+		}
+	}
+
+	if *argSoakDuration < 0 || *argSoakRestoreInterval <= 0 || *argSoakErrorBudget < 0 {
+		check(fmt.Errorf("invalid soak settings: duration %v, restore interval %v, error budget %d", *argSoakDuration, *argSoakRestoreInterval, *argSoakErrorBudget))
+	}
+
+	if *argLoadPlans > 0 {
+		if *argLoadNamespaces <= 0 || *argLoadJobsPerPlan < 0 || *argLoadJobInterval <= 0 || *argLoadConcurrency <= 0 || *argLoadMaxRunningJobs <= 0 {
+			check(fmt.Errorf("invalid load settings: namespaces %d, jobs per plan %d, job interval %v, concurrency %d, max running jobs %d",
+				*argLoadNamespaces, *argLoadJobsPerPlan, *argLoadJobInterval, *argLoadConcurrency, *argLoadMaxRunningJobs))
+		}
+		if *argLoadMaxErrorRate < 0 || *argLoadMaxErrorRate > 1 {
+			check(fmt.Errorf("invalid load max error rate %v: must be between 0 and 1", *argLoadMaxErrorRate))
+		}
+	}
+
+	if *argBenchSeedJobs < 0 {
+		check(fmt.Errorf("invalid bench seed jobs %d: must not be negative", *argBenchSeedJobs))
+	}
+
+	if *argBackupRepeatEnabled || *argSoakDuration > 0 {
 		if _, err := cron.ParseStandard(*argBackupFrequency); err != nil {
-			return fmt.Errorf("invalid backup frequency %s: %v", *argBackupFrequency, err)
+			check(fmt.Errorf("invalid backup frequency %s: %v", *argBackupFrequency, err))
 		}
 	}
 
+	if *argWorkloadManifestDir != "" && !isDir(*argWorkloadManifestDir) {
+		check(fmt.Errorf("invalid workload manifest dir %s: not a directory", *argWorkloadManifestDir))
+	}
+	if *argWorkloadManifestDir != "" && *argBackupNamespace != "" {
+		check(fmt.Errorf("jibu-workload-manifest-dir seeds a new namespace, it can't be used with jibu-backup-namespace %s", *argBackupNamespace))
+	}
+	if *argGenerationFixture && *argBackupNamespace != "" {
+		check(fmt.Errorf("jibu-generation-fixture-enabled deploys into a new namespace, it can't be used with jibu-backup-namespace %s", *argBackupNamespace))
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// validateEndpoint checks the api endpoint is an absolute http(s) url
+func validateEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid api endpoint %s: %v", endpoint, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid api endpoint %s: expected http(s)://host[:port]", endpoint)
+	}
+	return nil
+}
+
+// validateTenant checks the tenant is a numeric id
+func validateTenant(flagName string, tenant string) error {
+	if !tenantPattern.MatchString(tenant) {
+		return fmt.Errorf("invalid %s %s: expected a numeric tenant id", flagName, tenant)
+	}
+	return nil
+}
+
+// validateName checks a plan, job or namespace name is a DNS-1123 label within maxLen, empty names are generated
+func validateName(flagName string, name string, maxLen int) error {
+	if name == "" {
+		return nil
+	}
+	if msgs := validation.IsDNS1123Label(name); len(msgs) != 0 {
+		return fmt.Errorf("invalid %s %s: %s", flagName, name, strings.Join(msgs, ", "))
+	}
+	if len(name) > maxLen {
+		return fmt.Errorf("invalid %s %s: must be no more than %d characters", flagName, name, maxLen)
+	}
 	return nil
 }
@@ -1,17 +1,14 @@
 package jibu
 
 import (
-	"flag"
 	"fmt"
-	"github.com/antihax/optional"
-	"github.com/davecgh/go-spew/spew"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
 	"math/rand"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -20,8 +17,8 @@ import (
 	"github.com/elliotchance/pie/pie"
 
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
 
 	swagger "github.com/jibutech/backup-saas-client"
 	"github.com/stoneshi-yunify/jibutest/pkg/utils/random"
@@ -32,28 +29,41 @@ import (
 )
 
 func TestBackupAndRestore(t *testing.T) {
+	if errs := validateFlags(); errs != nil {
+		for _, err := range errs.Errors() {
+			t.Errorf("invalid flags: %v", err)
+		}
+		t.FailNow()
+	}
 	rand.Seed(time.Now().UnixNano())
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "backup and restore")
 }
 
-var _ = Describe("use jibu api", func() {
-	flag.Parse()
-	flag.VisitAll(func(f *flag.Flag) {
-		MyBy(fmt.Sprintf("%s = %v", f.Name, f.Value))
-	})
-	if err := validateFlags(); err != nil {
-		panic(err.Error())
+var _ = AfterSuite(func() {
+	if *argCleanUpOnEnd {
+		runCleanups()
 	}
+})
+
+var _ = Describe("use jibu api", func() {
+	_ = parseFlags()
+	MyBy("effective configuration\n" + effectiveConfig())
 
 	tenant := *argTenant
 	jibuAPIEndpoint := *argJibuAPIEndpoint
-	excludeNamespaces := pie.Strings(strings.Split(*argExcludeNamespaces, ","))
+	nsFilter, _ := newNamespaceFilterFromFlags()
+	clFilter, _ := newClusterFilterFromFlags()
+	stFilter, _ := newStorageFilterFromFlags()
+	pickMode := PickMode(*argPickMode)
+	pickStateFile := *argPickStateFile
 	restoreToSameNamespace := *argRestoreToSameNamespace
+	restoreDRMode := *argRestoreDRMode
 	backupRepeatEnabled := *argBackupRepeatEnabled
 	backupRepeatCheckNum := *argBackupRepeatCheckNum
 	backupFrequency := *argBackupFrequency
 	backupWithPV := *argBackupWithPV
+	excludedPVBehavior := ExcludedPVBehavior(*argExcludedPVBehavior)
 	backupCopyMethod := *argBackupCopyMethod
 	backupNamespace := *argBackupNamespace
 	restoreNamespace := *argRestoreNamespace
@@ -63,10 +73,23 @@ var _ = Describe("use jibu api", func() {
 	restoreCluster := *argRestoreCluster
 	backupStorage := *argStorage
 	cleanUpOnEnd := *argCleanUpOnEnd
+	preflightEnabled := *argPreflightEnabled
+	controllerNamespaces := splitList(*argControllerNamespaces)
+	requiredCRDs := splitList(*argRequiredCRDs)
 	backupPlanName := *argBackupPlanName
 	backupJobName := *argBackupJobName
 	restorePlanName := *argRestorePlanName
 	restoreJobName := *argRestoreJobName
+	workloadManifestDir := *argWorkloadManifestDir
+	generationFixtureEnabled := *argGenerationFixture
+	restorePoint := *argRestorePoint
+	crdFixtureEnabled := *argCRDFixture
+	generations := &generationTracker{}
+	// the workload and the generation fixture only go into a namespace created by the suite,
+	// backupNamespaceCreated is set once it's created, only then it's deleted at the end
+	backupNamespaceOwned := workloadManifestDir != "" || generationFixtureEnabled
+	backupNamespaceCreated := false
+	var crdFixture []*unstructured.Unstructured
 
 	var timestamp = time.Now().Format("20060102150405")
 	if backupPlanName == "" {
@@ -81,10 +104,11 @@ var _ = Describe("use jibu api", func() {
 	if restoreJobName == "" {
 		restoreJobName = strings.ToLower(fmt.Sprintf("%s-%s", restorePlanName, random.GetRandString(5)))
 	}
+	if backupNamespaceOwned && backupNamespace == "" {
+		backupNamespace = strings.ToLower(fmt.Sprintf("seed-%v", timestamp))
+	}
 
-	jibuConf := swagger.NewConfiguration()
-	jibuConf.BasePath = jibuAPIEndpoint
-	jibuClient := swagger.NewAPIClient(jibuConf)
+	jibuClient := newJibuClient(jibuAPIEndpoint)
 
 	Context("create a backup job and a restore job", func() {
 		BeforeEach(func() {
@@ -103,24 +127,49 @@ var _ = Describe("use jibu api", func() {
 				if backupRepeatEnabled {
 					backupPlan, _, err := jibuClient.BackupPlanTagApi.GetBackupPlan(ctx, tenant, backupPlanName)
 					if err == nil {
-						MyBy(spew.Sdump("backup plan", backupPlan))
+						MyBy(redactedDump("backup plan", backupPlan))
 					}
 					_, _, _ = jibuClient.BackupPlanTagApi.DeleteBackupPlan(ctx, tenant, backupPlanName)
 				}
 				_ = deleteJobsOfBackupPlan(jibuClient, tenant, backupPlanName)
 				restoreJob, _, err := jibuClient.RestoreJobTagApi.GetRestoreJob(ctx, tenant, restoreJobName)
 				if err == nil {
-					MyBy(spew.Sdump("restorejob", restoreJob))
+					MyBy(redactedDump("restorejob", restoreJob))
 				}
 				// _, _, _ = jibuClient.RestorePlanTagApi.DeleteRestorePlan(ctx, tenant, restorePlanName)
 				_, _, _ = jibuClient.RestoreJobTagApi.DeleteRestoreJob(ctx, tenant, restoreJobName)
+				if generationFixtureEnabled && backupNamespaceCreated {
+					MyBy(fmt.Sprintf("delete generation fixture in namespace %s", backupNamespace))
+					k8sClient, _ := getK8sClientFromCluster(jibuClient, tenant, backupCluster)
+					deleteGenerationFixture(k8sClient, backupNamespace)
+				}
+				if crdFixtureEnabled {
+					for _, cluster := range (pie.Strings{backupCluster, restoreCluster}).Unique() {
+						if len(cluster) == 0 {
+							continue
+						}
+						MyBy(fmt.Sprintf("delete CRD %s in cluster %s", crdFixtureName, cluster))
+						_, dynamicClient := getK8sClientFromCluster(jibuClient, tenant, cluster)
+						if err = deleteCRDFixture(dynamicClient); err != nil {
+							log.with(logKeyCluster, cluster).warn("failed to delete CRD", "crd", crdFixtureName, "error", err)
+						}
+					}
+				}
+				if backupNamespaceCreated {
+					MyBy(fmt.Sprintf("delete seeded namespace %s", backupNamespace))
+					k8sClient, dynamicClient := getK8sClientFromCluster(jibuClient, tenant, backupCluster)
+					err = deleteNamespace(k8sClient, dynamicClient, backupNamespace, true)
+					if err != nil {
+						log.with(logKeyCluster, backupCluster, logKeyNamespace, backupNamespace).warn("failed to delete namespace", "error", err)
+					}
+				}
 				if backupNamespace != restoreNamespace || backupCluster != restoreCluster {
 					if len(backupCluster) != 0 && len(restoreNamespace) != 0 {
 						MyBy(fmt.Sprintf("delete namespace %s", restoreNamespace))
 						k8sClient, dynamicClient := getK8sClientFromCluster(jibuClient, tenant, restoreCluster)
 						err = deleteNamespace(k8sClient, dynamicClient, restoreNamespace, true)
 						if err != nil {
-							MyBy(fmt.Sprintf("failed to delete namespace %s, error: %v", restoreNamespace, err))
+							log.with(logKeyCluster, restoreCluster, logKeyNamespace, restoreNamespace).warn("failed to delete namespace", "error", err)
 						}
 					}
 				}
@@ -129,77 +178,113 @@ var _ = Describe("use jibu api", func() {
 
 		It("should succeed", func() {
 			var err error
-			var meta swagger.V1ObjectMeta
+			// clusters already checked by preflight
+			preflighted := map[string]bool{}
+
+			// the picks go through the api, so it's checked first
+			if preflightEnabled {
+				report := runAPIPreflight(jibuClient, jibuAPIEndpoint, tenant)
+				MyBy("api preflight checks\n" + report.String())
+				Expect(report.passed()).Should(BeTrue(), "api preflight checks failed")
+			}
 
 			if !skipBackup {
 				MyBy("pick a cluster for backup")
-				cluster := pickOneCluster(jibuClient, tenant, backupCluster)
+				cluster := pickOneCluster(jibuClient, tenant, ClusterRoleBackup, backupCluster, clFilter, pickMode, pickStateFile)
 				backupCluster = cluster.Metadata.Name
-				MyBy(fmt.Sprintf("cluster is picked, id=%s, display-name=%s", backupCluster, cluster.Spec.DisplayName))
-
-				MyBy("pick a namespace")
-				ns := pickOneNamespace(jibuClient, tenant, backupCluster, backupNamespace, excludeNamespaces)
-				backupNamespace = ns.Metadata.Name
-				MyBy(fmt.Sprintf("namespace %s is picked", backupNamespace))
+				log.with(logKeyCluster, backupCluster).info("cluster is picked for backup", "display-name", cluster.Spec.DisplayName)
+				startCRWatch(jibuClient, tenant, backupCluster)
 
 				MyBy("pick a storage")
-				storage := pickOneStorage(jibuClient, tenant, backupStorage)
+				storage := pickOneStorage(jibuClient, tenant, backupStorage, stFilter, pickMode, pickStateFile)
 				backupStorage = storage.Metadata.Name
 				MyBy(fmt.Sprintf("storage is picked, id=%s, display-name=%s", backupStorage, storage.Spec.DisplayName))
 
-				MyBy("create a backup plan")
-				meta = swagger.V1ObjectMeta{
-					Name: backupPlanName,
+				if preflightEnabled {
+					clusters := []string{backupCluster}
+					if restoreCluster != "" && restoreCluster != backupCluster {
+						clusters = append(clusters, restoreCluster)
+					}
+					report := runPreflight(jibuClient, preflightOptions{
+						tenant:               tenant,
+						clusters:             clusters,
+						storage:              storage,
+						copyMethod:           backupCopyMethod,
+						controllerNamespaces: controllerNamespaces,
+						requiredCRDs:         requiredCRDs,
+					})
+					MyBy("preflight checks\n" + report.String())
+					Expect(report.passed()).Should(BeTrue(), "preflight checks failed")
+					for _, c := range clusters {
+						preflighted[c] = true
+					}
 				}
-				backupPolicy := swagger.V1alpha1BackupPolicy{
-					Retention: jobRetention,
-					Repeat:    backupRepeatEnabled,
-					Frequency: backupFrequency,
+
+				if backupNamespaceOwned {
+					MyBy(fmt.Sprintf("create namespace %s", backupNamespace))
+					k8sClient, dynamicClient := getK8sClientFromCluster(jibuClient, tenant, backupCluster)
+					err = createNamespace(k8sClient, backupNamespace)
+					Expect(err).ShouldNot(HaveOccurred())
+					backupNamespaceCreated = true
+					if workloadManifestDir != "" {
+						MyBy(fmt.Sprintf("seed namespace %s with manifests in %s", backupNamespace, workloadManifestDir))
+						err = seedWorkload(k8sClient, dynamicClient, workloadManifestDir, backupNamespace)
+						Expect(err).ShouldNot(HaveOccurred())
+						MyBy(fmt.Sprintf("workload in namespace %s is ready", backupNamespace))
+					}
+				} else {
+					MyBy("pick a namespace")
+					ns := pickOneNamespace(jibuClient, tenant, backupCluster, backupNamespace, nsFilter)
+					backupNamespace = ns.Metadata.Name
+					log.with(logKeyCluster, backupCluster, logKeyNamespace, backupNamespace).info("namespace is picked for backup")
 				}
-				backupPlanSpec := swagger.V1alpha1BackupPlanSpec{
-					ClusterName: backupCluster,
-					CopyMethod:  backupCopyMethod,
-					Desc:        backupPlanName,
-					DisplayName: backupPlanName,
-					ExcludePV:   !backupWithPV,
-					Namespaces:  []string{backupNamespace},
-					Policy:      &backupPolicy,
-					StorageName: backupStorage,
-					Tenant:      tenant,
+
+				if generationFixtureEnabled {
+					MyBy(fmt.Sprintf("deploy generation fixture into namespace %s", backupNamespace))
+					k8sClient, _ := getK8sClientFromCluster(jibuClient, tenant, backupCluster)
+					err = deployGenerationFixture(k8sClient, backupNamespace)
+					Expect(err).ShouldNot(HaveOccurred())
+					stamped, err := stampGeneration(k8sClient, backupNamespace, 0)
+					Expect(err).ShouldNot(HaveOccurred())
+					generations.record(0, stamped)
+					MyBy("generation 0 is written")
 				}
-				backupPlan := swagger.V1alpha1BackupPlan{
-					Metadata: &meta,
-					Spec:     &backupPlanSpec,
+
+				if crdFixtureEnabled {
+					MyBy(fmt.Sprintf("create custom resources of CRD %s in namespace %s", crdFixtureName, backupNamespace))
+					_, dynamicClient := getK8sClientFromCluster(jibuClient, tenant, backupCluster)
+					crdFixture, err = deployCRDFixture(dynamicClient, backupNamespace)
+					Expect(err).ShouldNot(HaveOccurred())
+					MyBy(fmt.Sprintf("%d custom resources created", len(crdFixture)))
 				}
+
+				err = measureBackupDataSize(jibuClient, tenant, backupCluster, backupNamespace)
+				Expect(err).ShouldNot(HaveOccurred())
+				log.with(logKeyNamespace, backupNamespace).info("job timeouts are set", "backup-data-size", backupDataSize(),
+					"backup-job-timeout", backupJobFinishedTimeout(), "restore-job-timeout", restoreJobFinishedTimeout())
+
+				MyBy("create a backup plan")
+				backupPlan := newBackupPlan(tenant, backupPlanName, backupCluster, backupStorage, []string{backupNamespace})
+				backupPlan.Spec.CopyMethod = backupCopyMethod
+				backupPlan.Spec.ExcludePV = !backupWithPV
+				backupPlan.Spec.Policy.Repeat = backupRepeatEnabled
+				backupPlan.Spec.Policy.Frequency = backupFrequency
 				_, _, err = jibuClient.BackupPlanTagApi.CreateBackupPlan(ctx, tenant, backupPlan)
 				Expect(err).ShouldNot(HaveOccurred())
-				MyBy(fmt.Sprintf("backup plan %s created", backupPlan.Metadata.Name))
+				log.with(logKeyCluster, backupCluster, logKeyNamespace, backupNamespace, logKeyPlan, backupPlanName).info("backup plan created")
 
-				MyBy(fmt.Sprintf("backup plan should be ready in %v", backupPlanReadyTimeout))
+				MyBy(fmt.Sprintf("backup plan should be ready in %v", backupPlanReadyTimeout()))
 				waitBackupPlanReady(jibuClient, tenant, backupPlanName)
 				MyBy("back plan is ready now")
 
 				if !backupRepeatEnabled {
 					MyBy("create a backup job")
-					meta = swagger.V1ObjectMeta{
-						Name: backupJobName,
-					}
-					backupJobSpec := swagger.V1alpha1BackupJobSpec{
-						Action:      actionStartJob,
-						BackupName:  backupPlanName,
-						Desc:        backupJobName,
-						DisplayName: backupJobName,
-						Tenant:      tenant,
-					}
-					backupJob := swagger.V1alpha1BackupJob{
-						Metadata: &meta,
-						Spec:     &backupJobSpec,
-					}
+					backupJob := newBackupJob(tenant, backupJobName, backupPlanName)
 					_, _, err = jibuClient.BackupJobTagApi.CreateBackupJob(ctx, tenant, backupJob)
 					Expect(err).ShouldNot(HaveOccurred())
-					MyBy(fmt.Sprintf("backup job %s created", backupJobName))
+					log.with(logKeyPlan, backupPlanName, logKeyJob, backupJobName).info("backup job created")
 
-					MyBy(fmt.Sprintf("backup job should complete in %v", backupJobFinishedTimeout))
+					MyBy(fmt.Sprintf("backup job should complete in %v", backupJobFinishedTimeout()))
 					waitBackupJobComplete(jibuClient, tenant, backupJobName)
 					MyBy("backup job succeeded")
 				} else {
@@ -211,6 +296,8 @@ var _ = Describe("use jibu api", func() {
 					// to find out which job they should be waiting for
 					indexChan := make(chan int, backupRepeatCheckNum)
 					wg := sync.WaitGroup{}
+					// a failed Expect in the cron goroutine is recovered there, the spec goroutine stops on this flag
+					var checkFailed int32
 					checkRepeatedBackupJob := func() {
 						var index int
 						select {
@@ -221,203 +308,180 @@ var _ = Describe("use jibu api", func() {
 						default:
 							return
 						}
-						MyBy(fmt.Sprintf("wait for backup job to be created in %v, index: %d", backupJobRepeatedCreationTimeout, index))
+						defer GinkgoRecover()
+						failed := true
+						defer func() {
+							if failed {
+								atomic.StoreInt32(&checkFailed, 1)
+							}
+						}()
+
+						MyBy(fmt.Sprintf("wait for backup job to be created in %v, index: %d", backupJobRepeatedCreationTimeout(), index))
 						jobName := waitNthBackupJobCreation(jibuClient, tenant, backupPlanName, index)
-						MyBy(fmt.Sprintf("backup job created, index: %d, name: %s", index, jobName))
-						MyBy(fmt.Sprintf("backup job should complete in %v, index: %d, name: %s", backupJobFinishedTimeout, index, jobName))
+						log.with(logKeyPlan, backupPlanName, logKeyJob, jobName).info("repeated backup job created", "index", index)
+						MyBy(fmt.Sprintf("backup job should complete in %v, index: %d, name: %s", backupJobFinishedTimeout(), index, jobName))
 						waitBackupJobComplete(jibuClient, tenant, jobName)
-						MyBy(fmt.Sprintf("backup job completed, index: %d, name: %s", index, jobName))
+						log.with(logKeyPlan, backupPlanName, logKeyJob, jobName).info("repeated backup job completed", "index", index)
+						if generationFixtureEnabled {
+							// the plan is paused while stamping, so no job catches the replicas with different generations
+							generation := generations.current() + 1
+							err := setBackupPlanRepeat(jibuClient, tenant, backupPlanName, false)
+							Expect(err).ShouldNot(HaveOccurred())
+							time.Sleep(planChangeGracePeriod)
+							k8sClient, _ := getK8sClientFromCluster(jibuClient, tenant, backupCluster)
+							stamped, err := stampGeneration(k8sClient, backupNamespace, generation)
+							Expect(err).ShouldNot(HaveOccurred())
+							generations.record(generation, stamped)
+							err = setBackupPlanRepeat(jibuClient, tenant, backupPlanName, true)
+							Expect(err).ShouldNot(HaveOccurred())
+							MyBy(fmt.Sprintf("generation %d is written for the next backup job", generation))
+						}
+						failed = false
 					}
 					c := cron.New()
 					_, err = c.AddFunc(backupFrequency, checkRepeatedBackupJob)
 					Expect(err).ShouldNot(HaveOccurred())
 					c.Start()
+					defer c.Stop()
 					for i := 0; i < backupRepeatCheckNum; i++ {
 						indexChan <- i
 						wg.Add(1)
 						wg.Wait()
+						Expect(atomic.LoadInt32(&checkFailed)).Should(BeZero(), "check of repeated backup job %d failed", i)
 					}
-					c.Stop()
 				}
 			}
 
 			if !skipRestore {
 				MyBy("pick a cluster for restore")
-				cluster := pickOneCluster(jibuClient, tenant, restoreCluster)
+				cluster := pickOneCluster(jibuClient, tenant, ClusterRoleRestore, restoreCluster, clFilter, pickMode, pickStateFile)
 				restoreCluster = cluster.Metadata.Name
-				MyBy(fmt.Sprintf("cluster is picked, id=%s, display-name=%s", restoreCluster, cluster.Spec.DisplayName))
+				log.with(logKeyCluster, restoreCluster).info("cluster is picked for restore", "display-name", cluster.Spec.DisplayName)
+				startCRWatch(jibuClient, tenant, restoreCluster)
+
+				// a restore cluster picked rather than set by flag hasn't been checked along with the backup
+				if preflightEnabled && !preflighted[restoreCluster] {
+					report := runPreflight(jibuClient, preflightOptions{
+						tenant:               tenant,
+						clusters:             []string{restoreCluster},
+						controllerNamespaces: controllerNamespaces,
+						requiredCRDs:         requiredCRDs,
+					})
+					MyBy("preflight checks\n" + report.String())
+					Expect(report.passed()).Should(BeTrue(), "preflight checks failed")
+					preflighted[restoreCluster] = true
+				}
 
 				MyBy("pick a namespace for restore")
 				if restoreNamespace == "" {
 					restoreNamespace = determineDestNamespaceName(restoreToSameNamespace, backupNamespace)
 				}
-				MyBy(fmt.Sprintf("namespace %s is picked", restoreNamespace))
+				log.with(logKeyCluster, restoreCluster, logKeyNamespace, restoreNamespace).info("namespace is picked for restore")
 
-				MyBy("create a restore plan")
-				meta = swagger.V1ObjectMeta{
-					Name: restorePlanName,
+				if restoreNamespace == backupNamespace && restoreCluster == backupCluster {
+					Expect(restoreDRMode).Should(BeTrue(), "restoring namespace %s into itself on cluster %s needs -jibu-restore-dr-mode", backupNamespace, backupCluster)
 				}
-				restorePlanSpec := swagger.V1alpha1RestorePlanSpec{
-					BackupName:        backupPlanName,
-					Desc:              restorePlanName,
-					DestClusterName:   restoreCluster,
-					DisplayName:       restorePlanName,
-					NamespaceMappings: []string{fmt.Sprintf("%s:%s", backupNamespace, restoreNamespace)},
-					Tenant:            tenant,
-				}
-				restorePlan := swagger.V1alpha1RestorePlan{
-					Metadata: &meta,
-					Spec:     &restorePlanSpec,
+
+				// the restore has to bring the CRD along when the target cluster doesn't have it yet
+				if len(crdFixture) != 0 && restoreCluster != backupCluster {
+					MyBy(fmt.Sprintf("make sure CRD %s doesn't exist in cluster %s", crdFixtureName, restoreCluster))
+					_, dynamicClient := getK8sClientFromCluster(jibuClient, tenant, restoreCluster)
+					err = deleteCRDFixture(dynamicClient)
+					Expect(err).ShouldNot(HaveOccurred())
 				}
+
+				MyBy("create a restore plan")
+				restorePlan := newRestorePlan(tenant, restorePlanName, backupPlanName, restoreCluster, []string{fmt.Sprintf("%s:%s", backupNamespace, restoreNamespace)})
 				_, _, err = jibuClient.RestorePlanTagApi.CreateRestorePlan(ctx, tenant, restorePlan)
 				Expect(err).ShouldNot(HaveOccurred())
-				MyBy(fmt.Sprintf("restore plan %s created", restorePlan.Metadata.Name))
+				log.with(logKeyCluster, restoreCluster, logKeyNamespace, restoreNamespace, logKeyPlan, restorePlanName).info("restore plan created")
 
 				MyBy("create a restore job")
-				meta = swagger.V1ObjectMeta{
-					Name: restoreJobName,
-				}
-				backupJobToRestore, err := pickOneJobOfBackupPlan(jibuClient, tenant, backupPlanName)
+				backupJobToRestore, err := pickOneJobOfBackupPlan(jibuClient, tenant, backupPlanName, restorePoint)
 				Expect(err).ShouldNot(HaveOccurred())
-				restoreJobSpec := swagger.V1alpha1RestoreJobSpec{
-					Action:        actionStartJob,
-					BackupJobName: backupJobToRestore.Metadata.Name,
-					Desc:          restoreJobName,
-					DisplayName:   restoreJobName,
-					RestoreName:   restorePlanName,
-					Tenant:        tenant,
-				}
-				restoreJob := swagger.V1alpha1RestoreJob{
-					Metadata: &meta,
-					Spec:     &restoreJobSpec,
-				}
+				MyBy(fmt.Sprintf("backup job %s is picked as restore point %d", backupJobToRestore.Metadata.Name, restorePoint))
+				restoreJob := newRestoreJob(tenant, restoreJobName, restorePlanName, backupJobToRestore.Metadata.Name)
 				_, _, err = jibuClient.RestoreJobTagApi.CreateRestoreJob(ctx, tenant, restoreJob)
 				Expect(err).ShouldNot(HaveOccurred())
-				MyBy(fmt.Sprintf("restore job %s created", restoreJob.Metadata.Name))
+				log.with(logKeyPlan, restorePlanName, logKeyJob, restoreJobName).info("restore job created", "backup-job", backupJobToRestore.Metadata.Name)
 
-				MyBy(fmt.Sprintf("restore plan should be ready in %v", restorePlanReadyTimeout))
+				MyBy(fmt.Sprintf("restore plan should be ready in %v", restorePlanReadyTimeout()))
 				waitRestorePlanReady(jibuClient, tenant, restorePlanName)
 				MyBy("restore plan is ready now")
 
-				MyBy(fmt.Sprintf("restore job should complete in %v", backupJobFinishedTimeout))
+				MyBy(fmt.Sprintf("restore job should complete in %v", restoreJobFinishedTimeout()))
 				waitRestoreJobComplete(jibuClient, tenant, restoreJobName)
 				MyBy("restore job succeeded")
-			}
-		})
-	})
-})
-
-func pickOneCluster(jibuClient *swagger.APIClient, tenant string, cluster string) *swagger.V1alpha1Cluster {
-	var c *swagger.V1alpha1Cluster
-
-	if cluster != "" {
-		opts := swagger.ClusterApiGetClusterOpts{IncludeKubeconfig: optional.NewString("false")}
-		c, _, err := jibuClient.ClusterApi.GetCluster(ctx, tenant, cluster, &opts)
-		Expect(err).ShouldNot(HaveOccurred())
-		return &c
-	}
-
-	clusterList, _, err := jibuClient.ClusterApi.ListClusters(ctx, tenant, nil)
-	Expect(err).ShouldNot(HaveOccurred())
-	Expect(clusterList.Items).ShouldNot(BeEmpty())
-	count := 0
-	for {
-		if count >= resourcePickRetryLimit {
-			break
-		}
-		index := rand.Intn(len(clusterList.Items))
-		c = &clusterList.Items[index]
-		if c.Status.Phase == string(PhaseReady) {
-			break
-		} else {
-			c = nil
-		}
-		count++
-	}
-	Expect(c).ShouldNot(BeNil())
-	return c
-}
-
-func pickOneStorage(jibuClient *swagger.APIClient, tenant string, storage string) *swagger.V1alpha1Storage {
-	var s *swagger.V1alpha1Storage
 
-	if storage != "" {
-		opts := swagger.StorageApiGetStorageOpts{IncludeSecrets: optional.NewString("false")}
-		s, _, err := jibuClient.StorageApi.GetStorage(ctx, tenant, storage, &opts)
-		Expect(err).ShouldNot(HaveOccurred())
-		return &s
-	}
+				if !skipBackup && (backupNamespace != restoreNamespace || backupCluster != restoreCluster) {
+					if backupWithPV {
+						MyBy(fmt.Sprintf("every pvc in namespace %s should have a bound counterpart in namespace %s", backupNamespace, restoreNamespace))
+					} else {
+						MyBy(fmt.Sprintf("pvcs in namespace %s should be %s since PVs are excluded", restoreNamespace, excludedPVBehavior))
+					}
+					srcK8sClient, _ := getK8sClientFromCluster(jibuClient, tenant, backupCluster)
+					dstK8sClient, _ := getK8sClientFromCluster(jibuClient, tenant, restoreCluster)
+					err = verifyRestoredPVCs(srcK8sClient, backupNamespace, dstK8sClient, restoreNamespace, backupWithPV, excludedPVBehavior)
+					Expect(err).ShouldNot(HaveOccurred())
+					MyBy("restored pvcs are verified")
+				}
 
-	storageList, _, err := jibuClient.StorageApi.ListStorages(ctx, tenant, nil)
-	Expect(err).ShouldNot(HaveOccurred())
-	Expect(storageList.Items).ShouldNot(BeEmpty())
-	count := 0
-	for {
-		if count >= resourcePickRetryLimit {
-			break
-		}
-		index := rand.Intn(len(storageList.Items))
-		s = &storageList.Items[index]
-		if s.Status.Phase == string(PhaseReady) {
-			break
-		} else {
-			s = nil
-		}
-		count++
-	}
-	Expect(s).ShouldNot(BeNil())
-	return s
-}
+				// the generations are stamped by the api server of the backup cluster while job creation time is set
+				// by the jibu server, no job is created around a stamp as the plan is paused meanwhile
+				if generationFixtureEnabled && generations.current() >= 0 {
+					expected, ok := generations.at(backupJobToRestore.Metadata.CreationTimestamp)
+					Expect(ok).Should(BeTrue(), "backup job %s was created before any generation was written", backupJobToRestore.Metadata.Name)
+					// without PVs the restored volumes must not carry any generation
+					if !backupWithPV {
+						expected = -1
+					}
+					MyBy(fmt.Sprintf("restored data should carry generation %d", expected))
+					k8sClient, _ := getK8sClientFromCluster(jibuClient, tenant, restoreCluster)
+					err = verifyRestoredGeneration(k8sClient, restoreNamespace, expected)
+					Expect(err).ShouldNot(HaveOccurred())
+					MyBy(fmt.Sprintf("restored data carries generation %d", expected))
+				}
 
-func pickOneNamespace(jibuClient *swagger.APIClient, tenant string, cluster string, namespace string, excludeNamespaces pie.Strings) *swagger.V1Namespace {
-	var ns *swagger.V1Namespace
-	nsList, _, err := jibuClient.ClusterApi.GetNamespaces(ctx, tenant, cluster)
-	Expect(err).ShouldNot(HaveOccurred())
-	Expect(nsList.Items).ShouldNot(BeEmpty())
+				if len(crdFixture) != 0 {
+					MyBy(fmt.Sprintf("custom resources should be restored in namespace %s", restoreNamespace))
+					_, dynamicClient := getK8sClientFromCluster(jibuClient, tenant, restoreCluster)
+					err = verifyRestoredCRDFixture(dynamicClient, restoreNamespace, crdFixture)
+					Expect(err).ShouldNot(HaveOccurred())
+					MyBy("custom resources are restored intact")
+				}
 
-	if namespace != "" {
-		for _, n := range nsList.Items {
-			if n.Metadata.Name == namespace {
-				ns = &n
-				break
+				if workloadManifestDir != "" && !skipBackup {
+					MyBy(fmt.Sprintf("seeded workloads should be restored in namespace %s", restoreNamespace))
+					objs, err := loadManifests(workloadManifestDir)
+					Expect(err).ShouldNot(HaveOccurred())
+					_, dynamicClient := getK8sClientFromCluster(jibuClient, tenant, restoreCluster)
+					getter := namespaceResourcesGetter(dynamicClient, restoreNamespace, workloadGVRs["Deployment"], workloadGVRs["StatefulSet"])
+					Eventually(getter, workloadReadyTimeout, pollInterval()).Should(ContainResources(workloadRefs(objs)...))
+					MyBy("seeded workloads are restored")
+				}
 			}
-		}
-		Expect(ns).ShouldNot(BeNil())
-		return ns
-	}
-
-	count := 0
-	for {
-		if count >= resourcePickRetryLimit {
-			break
-		}
-		index := rand.Intn(len(nsList.Items))
-		ns = &nsList.Items[index]
-		if !excludeNamespaces.Contains(ns.Metadata.Name) {
-			break
-		} else {
-			ns = nil
-		}
-		count++
-	}
-	Expect(ns).ShouldNot(BeNil())
-	return ns
-}
+		})
+	})
+})
 
-func pickOneJobOfBackupPlan(jibuClient *swagger.APIClient, tenant string, planName string) (*swagger.V1alpha1BackupJob, error) {
-	listOpts := &swagger.BackupJobTagApiListBackupJobsOpts{
-		PlanName:  optional.NewString(planName),
-		SortBy:    optional.NewString(FieldCreationTimeStamp),
-		Ascending: optional.NewString(strconv.FormatBool(true)),
-	}
-	jobList, _, err := jibuClient.BackupJobTagApi.ListBackupJobs(ctx, tenant, listOpts)
+// pickOneJobOfBackupPlan picks the index-th job of the plan sorted by creation time,
+// negative index counts from the latest job
+func pickOneJobOfBackupPlan(jibuClient *swagger.APIClient, tenant string, planName string, index int) (*swagger.V1alpha1BackupJob, error) {
+	jobs, err := listAllBackupJobs(jibuClient, tenant, newListQuery().byPlan(planName).sortBy(listFieldCreationTimestamp, true))
 	if err != nil {
 		return nil, err
 	}
-	if len(jobList.Items) <= 0 {
+	if len(jobs) <= 0 {
 		return nil, fmt.Errorf("no backup job found")
 	}
-	return &jobList.Items[0], nil
+	i := index
+	if i < 0 {
+		i += len(jobs)
+	}
+	if i < 0 || i >= len(jobs) {
+		return nil, fmt.Errorf("restore point %d is out of range, %d backup jobs found", index, len(jobs))
+	}
+	return &jobs[i], nil
 }
 
 func determineDestNamespaceName(restoreToSameNamespace bool, backupNamespaceName string) string {
@@ -433,95 +497,132 @@ func determineDestNamespaceName(restoreToSameNamespace bool, backupNamespaceName
 }
 
 func waitBackupPlanReady(jibuClient *swagger.APIClient, tenant string, backupPlanName string) {
-	backupPlanReadyCondFunc := func() (bool, error) {
+	err := pollBackupPlanReady(jibuClient, tenant, backupPlanName)
+	Expect(err).ShouldNot(HaveOccurred())
+}
+
+func waitRestorePlanReady(jibuClient *swagger.APIClient, tenant string, restorePlanName string) {
+	err := pollRestorePlanReady(jibuClient, tenant, restorePlanName)
+	Expect(err).ShouldNot(HaveOccurred())
+}
+
+func waitBackupJobComplete(jibuClient *swagger.APIClient, tenant string, backupJobName string) {
+	err := pollBackupJobComplete(jibuClient, tenant, backupJobName)
+	Expect(err).ShouldNot(HaveOccurred())
+}
+
+func waitRestoreJobComplete(jibuClient *swagger.APIClient, tenant string, restoreJobName string) {
+	err := pollRestoreJobComplete(jibuClient, tenant, restoreJobName)
+	Expect(err).ShouldNot(HaveOccurred())
+}
+
+// pollBackupPlanReady is waitBackupPlanReady returning the failure instead of failing the spec,
+// so that it can be used out of the spec goroutine
+func pollBackupPlanReady(jibuClient *swagger.APIClient, tenant string, backupPlanName string) error {
+	var phase string
+	getBackupPlanPhase := func() (string, error) {
 		p, _, err := jibuClient.BackupPlanTagApi.GetBackupPlan(ctx, tenant, backupPlanName)
 		if err != nil {
-			return false, err
+			return "", err
 		}
-		if p.Status.Phase == string(PhaseReady) {
-			return true, nil
-		}
-		return false, nil
+		phase = p.Status.Phase
+		observePhase(kindBackupPlan, backupPlanName, phase, p.Status.Message, p.Status)
+		return phase, nil
 	}
-	err := wait.Poll(5*time.Second, backupPlanReadyTimeout, backupPlanReadyCondFunc)
-	Expect(err).ShouldNot(HaveOccurred())
-
-	backupPlanCreated, _, err := jibuClient.BackupPlanTagApi.GetBackupPlan(ctx, tenant, backupPlanName)
-	Expect(err).ShouldNot(HaveOccurred())
-	Expect(backupPlanCreated.Status.Phase).Should(Equal(string(PhaseReady)))
+	err := waitPhase(kindBackupPlan, backupPlanName, backupPlanReadyTimeout(), isPlanReady, getBackupPlanPhase)
+	if err == wait.ErrWaitTimeout {
+		return newPhaseFailure(failureBackupPlanReadyTimeout, "backup plan %s is %s, not ready in %v", backupPlanName, phase, backupPlanReadyTimeout())
+	}
+	return err
 }
 
-func waitRestorePlanReady(jibuClient *swagger.APIClient, tenant string, restorePlanName string) {
-	restorePlanReadyCondFunc := func() (bool, error) {
+func pollRestorePlanReady(jibuClient *swagger.APIClient, tenant string, restorePlanName string) error {
+	var phase string
+	getRestorePlanPhase := func() (string, error) {
 		p, _, err := jibuClient.RestorePlanTagApi.GetRestorePlan(ctx, tenant, restorePlanName)
 		if err != nil {
-			return false, err
-		}
-		if p.Status.Phase == string(PhaseReady) {
-			return true, nil
+			return "", err
 		}
-		return false, nil
+		phase = p.Status.Phase
+		observePhase(kindRestorePlan, restorePlanName, phase, p.Status.Message, p.Status)
+		return phase, nil
 	}
-	err := wait.Poll(5*time.Second, restorePlanReadyTimeout, restorePlanReadyCondFunc)
-	Expect(err).ShouldNot(HaveOccurred())
-
-	restorePlanCreated, _, err := jibuClient.RestorePlanTagApi.GetRestorePlan(ctx, tenant, restorePlanName)
-	Expect(err).ShouldNot(HaveOccurred())
-	Expect(restorePlanCreated.Status.Phase).Should(Equal(string(PhaseReady)))
+	err := waitPhase(kindRestorePlan, restorePlanName, restorePlanReadyTimeout(), isPlanReady, getRestorePlanPhase)
+	if err == wait.ErrWaitTimeout {
+		return newPhaseFailure(failureRestorePlanReadyTimeout, "restore plan %s is %s, not ready in %v", restorePlanName, phase, restorePlanReadyTimeout())
+	}
+	return err
 }
 
-func waitBackupJobComplete(jibuClient *swagger.APIClient, tenant string, backupJobName string) {
-	backupJobStoppedCondFunc := func() (bool, error) {
+func pollBackupJobComplete(jibuClient *swagger.APIClient, tenant string, backupJobName string) error {
+	var phase, message string
+	getBackupJobPhase := func() (string, error) {
 		job, _, err := jibuClient.BackupJobTagApi.GetBackupJob(ctx, tenant, backupJobName)
 		if err != nil {
-			return false, err
-		}
-		if job.Status.Phase == string(JobPhaseCompleted) || job.Status.Phase == string(JobPhaseFailed) || job.Status.Phase == string(JobPhaseCanceled) {
-			return true, nil
+			return "", err
 		}
-		return false, nil
+		phase, message = job.Status.Phase, job.Status.Message
+		observePhase(kindBackupJob, backupJobName, phase, message, job.Status)
+		return phase, nil
 	}
-	err := wait.Poll(5*time.Second, backupJobFinishedTimeout, backupJobStoppedCondFunc)
-	Expect(err).ShouldNot(HaveOccurred())
-
-	backupJobCreated, _, err := jibuClient.BackupJobTagApi.GetBackupJob(ctx, tenant, backupJobName)
-	Expect(err).ShouldNot(HaveOccurred())
-	Expect(backupJobCreated.Status.Phase).Should(Equal(string(JobPhaseCompleted)))
+	err := waitPhase(kindBackupJob, backupJobName, backupJobFinishedTimeout(), isJobStopped, getBackupJobPhase)
+	if err == wait.ErrWaitTimeout {
+		return newPhaseFailure(failureBackupJobTimeout, "backup job %s is %s, not finished in %v", backupJobName, phase, backupJobFinishedTimeout())
+	}
+	if err != nil {
+		return err
+	}
+	if phase != string(JobPhaseCompleted) {
+		return newPhaseFailure(failureJobFailed, "backup job %s is %s, expected %s, message: %s, phase history: %s",
+			backupJobName, phase, JobPhaseCompleted, message, phaseHistory(kindBackupJob, backupJobName))
+	}
+	return nil
 }
 
-func waitRestoreJobComplete(jibuClient *swagger.APIClient, tenant string, restoreJobName string) {
-	restoreJobStoppedCondFunc := func() (bool, error) {
+func pollRestoreJobComplete(jibuClient *swagger.APIClient, tenant string, restoreJobName string) error {
+	var phase, message string
+	getRestoreJobPhase := func() (string, error) {
 		job, _, err := jibuClient.RestoreJobTagApi.GetRestoreJob(ctx, tenant, restoreJobName)
 		if err != nil {
-			return false, err
-		}
-		if job.Status.Phase == string(JobPhaseCompleted) || job.Status.Phase == string(JobPhaseFailed) || job.Status.Phase == string(JobPhaseCanceled) {
-			return true, nil
+			return "", err
 		}
-		return false, nil
+		phase, message = job.Status.Phase, job.Status.Message
+		observePhase(kindRestoreJob, restoreJobName, phase, message, job.Status)
+		return phase, nil
 	}
-	err := wait.Poll(5*time.Second, restoreJobFinishedTimeout, restoreJobStoppedCondFunc)
-	Expect(err).ShouldNot(HaveOccurred())
+	err := waitPhase(kindRestoreJob, restoreJobName, restoreJobFinishedTimeout(), isJobStopped, getRestoreJobPhase)
+	if err == wait.ErrWaitTimeout {
+		return newPhaseFailure(failureRestoreJobTimeout, "restore job %s is %s, not finished in %v", restoreJobName, phase, restoreJobFinishedTimeout())
+	}
+	if err != nil {
+		return err
+	}
+	if phase != string(JobPhaseCompleted) {
+		return newPhaseFailure(failureJobFailed, "restore job %s is %s, expected %s, message: %s, phase history: %s",
+			restoreJobName, phase, JobPhaseCompleted, message, phaseHistory(kindRestoreJob, restoreJobName))
+	}
+	return nil
+}
 
-	restoreJobCreated, _, err := jibuClient.RestoreJobTagApi.GetRestoreJob(ctx, tenant, restoreJobName)
-	Expect(err).ShouldNot(HaveOccurred())
-	Expect(restoreJobCreated.Status.Phase).Should(Equal(string(JobPhaseCompleted)))
+// setBackupPlanRepeat pauses or resumes a repeated backup plan and waits for it to be ready again
+func setBackupPlanRepeat(jibuClient *swagger.APIClient, tenant string, backupPlanName string, repeat bool) error {
+	plan, _, err := jibuClient.BackupPlanTagApi.GetBackupPlan(ctx, tenant, backupPlanName)
+	if err != nil {
+		return err
+	}
+	plan.Spec.Policy.Repeat = repeat
+	if _, _, err = jibuClient.BackupPlanTagApi.UpdateBackupPlan(ctx, tenant, backupPlanName, plan); err != nil {
+		return err
+	}
+	return pollBackupPlanReady(jibuClient, tenant, backupPlanName)
 }
 
-func getK8sClientFromCluster(jibuClient *swagger.APIClient, tenant string, cluster string) (kubernetes.Interface, dynamic.Interface) {
-	opts := swagger.ClusterApiGetClusterOpts{IncludeKubeconfig: optional.NewString("true")}
-	c, _, err := jibuClient.ClusterApi.GetCluster(ctx, tenant, cluster, &opts)
-	Expect(err).ShouldNot(HaveOccurred())
-	kubeconfigBytes := []byte(c.Spec.Kubeconfig)
-	clientConfig, err := clientcmd.NewClientConfigFromBytes(kubeconfigBytes)
-	Expect(err).ShouldNot(HaveOccurred())
-	restClient, err := clientConfig.ClientConfig()
-	Expect(err).ShouldNot(HaveOccurred())
-	kubeClient, err := kubernetes.NewForConfig(restClient)
-	Expect(err).ShouldNot(HaveOccurred())
-	dynamicClient, err := dynamic.NewForConfig(restClient)
-	Expect(err).ShouldNot(HaveOccurred())
-	return kubeClient, dynamicClient
+func isPlanReady(phase string) bool {
+	return phase == string(PhaseReady)
+}
+
+func isJobStopped(phase string) bool {
+	return phase == string(JobPhaseCompleted) || phase == string(JobPhaseFailed) || phase == string(JobPhaseCanceled)
 }
 
 func deleteNamespace(kubeClient kubernetes.Interface, dynamicClient dynamic.Interface, namespace string, force bool) error {
@@ -544,7 +645,7 @@ func deleteNamespace(kubeClient kubernetes.Interface, dynamicClient dynamic.Inte
 		}
 		return false, err
 	}
-	_ = wait.Poll(5*time.Second, 2*time.Minute, namespaceGoneCheckFunc)
+	_ = wait.Poll(pollInterval(), 2*time.Minute, namespaceGoneCheckFunc)
 
 	_, err = kubeClient.CoreV1().Namespaces().Get(ctx, namespace, v1.GetOptions{})
 	if err != nil {
@@ -556,7 +657,7 @@ func deleteNamespace(kubeClient kubernetes.Interface, dynamicClient dynamic.Inte
 
 	gracePeriod := int64(0)
 	_ = kubeClient.CoreV1().Namespaces().Delete(ctx, namespace, v1.DeleteOptions{GracePeriodSeconds: &gracePeriod})
-	_ = wait.Poll(5*time.Second, 2*time.Minute, namespaceGoneCheckFunc)
+	_ = wait.Poll(pollInterval(), 2*time.Minute, namespaceGoneCheckFunc)
 
 	_, err = kubeClient.CoreV1().Namespaces().Get(ctx, namespace, v1.GetOptions{})
 	if err != nil {
@@ -602,15 +703,13 @@ func deleteNamespace(kubeClient kubernetes.Interface, dynamicClient dynamic.Inte
 }
 
 func deleteJobsOfBackupPlan(jibuClient *swagger.APIClient, tenant string, planName string) error {
-	listOpts := &swagger.BackupJobTagApiListBackupJobsOpts{
-		PlanName: optional.NewString(planName),
-	}
+	// all the pages are listed before any deletion, deleting while paging would shift the later pages
 	var retErr error
-	jobList, _, err := jibuClient.BackupJobTagApi.ListBackupJobs(ctx, tenant, listOpts)
+	jobs, err := listAllBackupJobs(jibuClient, tenant, newListQuery().byPlan(planName))
 	if err != nil {
 		retErr = err
 	} else {
-		for _, j := range jobList.Items {
+		for _, j := range jobs {
 			_, _, err = jibuClient.BackupJobTagApi.DeleteBackupJob(ctx, tenant, j.Metadata.Name)
 			if err != nil {
 				retErr = err
@@ -622,23 +721,22 @@ func deleteJobsOfBackupPlan(jibuClient *swagger.APIClient, tenant string, planNa
 
 func waitNthBackupJobCreation(jibuClient *swagger.APIClient, tenant string, planName string, index int) string {
 	var jobName string
-	listOpts := &swagger.BackupJobTagApiListBackupJobsOpts{
-		PlanName:  optional.NewString(planName),
-		SortBy:    optional.NewString(FieldCreationTimeStamp),
-		Ascending: optional.NewString(strconv.FormatBool(true)),
-	}
+	q := newListQuery().byPlan(planName).sortBy(listFieldCreationTimestamp, true)
 	nthJobCreatedFunc := func() (bool, error) {
-		jobList, _, err := jibuClient.BackupJobTagApi.ListBackupJobs(ctx, tenant, listOpts)
+		jobs, err := listAllBackupJobs(jibuClient, tenant, q)
 		if err != nil {
 			return false, err
 		}
-		if len(jobList.Items) < index+1 {
+		if len(jobs) < index+1 {
 			return false, nil
 		}
-		jobName = jobList.Items[index].Metadata.Name
+		jobName = jobs[index].Metadata.Name
 		return true, nil
 	}
-	err := wait.Poll(5*time.Second, backupJobRepeatedCreationTimeout, nthJobCreatedFunc)
+	err := wait.Poll(pollInterval(), backupJobRepeatedCreationTimeout(), nthJobCreatedFunc)
+	if err == wait.ErrWaitTimeout {
+		err = newPhaseFailure(failureBackupJobCreationTimeout, "backup job %d of plan %s is not created in %v", index, planName, backupJobRepeatedCreationTimeout())
+	}
 	Expect(err).ShouldNot(HaveOccurred())
 	return jobName
 }
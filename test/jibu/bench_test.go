@@ -0,0 +1,221 @@
+package jibu
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	swagger "github.com/jibutech/backup-saas-client"
+
+	. "github.com/onsi/gomega"
+)
+
+const benchSeedConcurrency = 20
+
+// benchFixture is the tenant the benchmarks run against, it's resolved and seeded once for all the benchmarks
+type benchFixture struct {
+	client   *swagger.APIClient
+	tenant   string
+	cluster  string
+	planName string
+}
+
+var (
+	sharedBench     *benchFixture
+	sharedBenchErr  error
+	sharedBenchOnce sync.Once
+)
+
+// errBenchFixtureAborted is left when the setup is aborted by a gomega failure of the pickers
+var errBenchFixtureAborted = errors.New("benchmark fixture setup is aborted, see the failure of the first benchmark")
+
+// benchSkip is returned when the backend can't hold the fixture, the benchmarks are skipped then
+type benchSkip struct {
+	reason string
+}
+
+func (s *benchSkip) Error() string {
+	return s.reason
+}
+
+// getBenchFixture fails the benchmark on invalid flags, the pickers shared with the specs fail it through gomega,
+// which ends the setup with runtime.Goexit, so the later benchmarks see errBenchFixtureAborted
+func getBenchFixture(b *testing.B) *benchFixture {
+	RegisterTestingT(b)
+	sharedBenchOnce.Do(func() {
+		sharedBenchErr = errBenchFixtureAborted
+		if errs := validateFlags(); errs != nil {
+			sharedBenchErr = fmt.Errorf("invalid flags: %v", errs)
+			return
+		}
+		sharedBench, sharedBenchErr = newBenchFixture(b)
+	})
+	var skip *benchSkip
+	if errors.As(sharedBenchErr, &skip) {
+		b.Skip(skip.reason)
+	}
+	if sharedBenchErr != nil {
+		b.Fatal(sharedBenchErr)
+	}
+	return sharedBench
+}
+
+// newBenchFixture uses the plan named by -jibu-bench-plan-name, or creates one for a new empty namespace,
+// then seeds it up to -jibu-bench-seed-jobs jobs. The plan and the namespace are kept, so later runs can reuse them
+// without seeding again.
+func newBenchFixture(b *testing.B) (*benchFixture, error) {
+	f := &benchFixture{
+		client:   newJibuClient(*argJibuAPIEndpoint),
+		tenant:   *argTenant,
+		planName: *argBenchPlanName,
+	}
+
+	if f.planName == "" {
+		if err := f.createPlan(b); err != nil {
+			return nil, err
+		}
+	}
+	plan, _, err := f.client.BackupPlanTagApi.GetBackupPlan(ctx, f.tenant, f.planName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backup plan %s: %v", f.planName, err)
+	}
+	f.cluster = plan.Spec.ClusterName
+	// older jobs beyond the retention are pruned, so the plan would never reach the seed count
+	if retention := int(plan.Spec.Policy.Retention); retention < *argBenchSeedJobs {
+		return nil, &benchSkip{reason: fmt.Sprintf("backup plan %s keeps %d jobs, less than the %d jobs to seed", f.planName, retention, *argBenchSeedJobs)}
+	}
+
+	if err := f.seedJobs(b, *argBenchSeedJobs); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *benchFixture) createPlan(b *testing.B) error {
+	clFilter, err := newClusterFilterFromFlags()
+	if err != nil {
+		return err
+	}
+	stFilter, err := newStorageFilterFromFlags()
+	if err != nil {
+		return err
+	}
+	f.cluster = pickOneCluster(f.client, f.tenant, ClusterRoleBackup, *argBackupCluster, clFilter, PickMode(*argPickMode), *argPickStateFile).Metadata.Name
+	storage := pickOneStorage(f.client, f.tenant, *argStorage, stFilter, PickMode(*argPickMode), *argPickStateFile).Metadata.Name
+
+	// every seeded job is a real backup run, so the plan backs up an empty namespace to keep them cheap
+	namespace := randomName("jibutest-bench")
+	k8sClient, _, err := newK8sClientFromCluster(f.client, f.tenant, f.cluster)
+	if err != nil {
+		return err
+	}
+	if err = createNamespace(k8sClient, namespace); err != nil {
+		return err
+	}
+
+	f.planName = randomName("bench")
+	plan := newBackupPlan(f.tenant, f.planName, f.cluster, storage, []string{namespace})
+	if *argBenchSeedJobs > int(plan.Spec.Policy.Retention) {
+		plan.Spec.Policy.Retention = int32(*argBenchSeedJobs)
+	}
+	if _, _, err := f.client.BackupPlanTagApi.CreateBackupPlan(ctx, f.tenant, plan); err != nil {
+		return fmt.Errorf("failed to create backup plan %s: %v", f.planName, err)
+	}
+	if err := pollBackupPlanReady(f.client, f.tenant, f.planName); err != nil {
+		return err
+	}
+	b.Logf("benchmark backup plan %s of namespace %s created, reuse it with -jibu-bench-plan-name=%s", f.planName, namespace, f.planName)
+	return nil
+}
+
+// seedJobs creates on-demand jobs until the plan has at least n jobs, it doesn't wait for them to complete
+func (f *benchFixture) seedJobs(b *testing.B, n int) error {
+	jobs, err := listAllBackupJobs(f.client, f.tenant, newListQuery().byPlan(f.planName))
+	if err != nil {
+		return err
+	}
+	missing := n - len(jobs)
+	if missing <= 0 {
+		return nil
+	}
+	b.Logf("seeding %d backup jobs into plan %s", missing, f.planName)
+
+	sem := make(chan struct{}, benchSeedConcurrency)
+	errs := make(chan error, missing)
+	var wg sync.WaitGroup
+	for i := 0; i < missing; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			job := newBackupJob(f.tenant, randomName(f.planName), f.planName)
+			if _, _, err := f.client.BackupJobTagApi.CreateBackupJob(ctx, f.tenant, job); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d backup jobs failed to be seeded, the first error: %v", len(errs), missing, <-errs)
+	}
+	return nil
+}
+
+func BenchmarkListBackupJobs(b *testing.B) {
+	f := getBenchFixture(b)
+	listOpts := newListQuery().byPlan(f.planName).sortBy(listFieldCreationTimestamp, true).backupJobOpts()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := f.client.BackupJobTagApi.ListBackupJobs(ctx, f.tenant, listOpts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkListClusters(b *testing.B) {
+	f := getBenchFixture(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := f.client.ClusterApi.ListClusters(ctx, f.tenant, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetNamespaces(b *testing.B) {
+	f := getBenchFixture(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := f.client.ClusterApi.GetNamespaces(ctx, f.tenant, f.cluster); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkListStorages(b *testing.B) {
+	f := getBenchFixture(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := f.client.StorageApi.ListStorages(ctx, f.tenant, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetBackupPlan(b *testing.B) {
+	f := getBenchFixture(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := f.client.BackupPlanTagApi.GetBackupPlan(ctx, f.tenant, f.planName); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package jibu
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// The cascade semantics below are what the specs expect from the jibu server:
+//   - deleting a backup plan keeps its jobs and their stored data, the jobs are deleted separately
+//   - a restore job can still be created and completed from a job whose backup plan is deleted
+//   - deleting a restore plan while one of its restore jobs is in progress is rejected with 409 Conflict,
+//     the restore job keeps running and the plan can be deleted once the job finishes
+
+// restoreJobStartTimeout is how long a restore job may take to leave JobNotStarted
+const restoreJobStartTimeout = 5 * time.Minute
+
+// orphanFixture holds a completed backup job whose plan has been deleted
+type orphanFixture struct {
+	backupPlanName string
+	backupJobName  string
+}
+
+var orphan *orphanFixture
+
+func getOrphanFixture(env *testEnv) *orphanFixture {
+	if orphan != nil {
+		return orphan
+	}
+
+	f := &orphanFixture{backupPlanName: randomName("cascade")}
+	f.backupJobName = fmt.Sprintf("%s-job", f.backupPlanName)
+	backupPlan := newBackupPlan(env.tenant, f.backupPlanName, env.cluster.Metadata.Name, env.storage.Metadata.Name, []string{env.namespace})
+	_, _, err := env.client.BackupPlanTagApi.CreateBackupPlan(ctx, env.tenant, backupPlan)
+	Expect(err).ShouldNot(HaveOccurred())
+	deferCleanup(func() {
+		_, _, _ = env.client.BackupPlanTagApi.DeleteBackupPlan(ctx, env.tenant, f.backupPlanName)
+		_ = deleteJobsOfBackupPlan(env.client, env.tenant, f.backupPlanName)
+	})
+	waitBackupPlanReady(env.client, env.tenant, f.backupPlanName)
+
+	backupJob := newBackupJob(env.tenant, f.backupJobName, f.backupPlanName)
+	_, _, err = env.client.BackupJobTagApi.CreateBackupJob(ctx, env.tenant, backupJob)
+	Expect(err).ShouldNot(HaveOccurred())
+	MyBy(fmt.Sprintf("backup job %s should complete in %v", f.backupJobName, backupJobFinishedTimeout()))
+	waitBackupJobComplete(env.client, env.tenant, f.backupJobName)
+
+	MyBy(fmt.Sprintf("delete backup plan %s", f.backupPlanName))
+	_, _, err = env.client.BackupPlanTagApi.DeleteBackupPlan(ctx, env.tenant, f.backupPlanName)
+	Expect(err).ShouldNot(HaveOccurred())
+	planGoneCondFunc := func() (bool, error) {
+		_, resp, err := env.client.BackupPlanTagApi.GetBackupPlan(ctx, env.tenant, f.backupPlanName)
+		return err != nil && apiStatusCode(resp) == http.StatusNotFound, nil
+	}
+	err = wait.Poll(pollInterval(), backupPlanReadyTimeout(), planGoneCondFunc)
+	Expect(err).ShouldNot(HaveOccurred(), "backup plan %s is not gone in %v", f.backupPlanName, backupPlanReadyTimeout())
+
+	orphan = f
+	return orphan
+}
+
+// restoreFrom creates a restore plan and a restore job from the backup job into a new namespace,
+// the restored namespace is deleted after the suite
+func restoreFrom(env *testEnv, backupPlanName string, backupJobName string) (string, string) {
+	restorePlanName := randomName("cascade-restore")
+	restoreJobName := fmt.Sprintf("%s-job", restorePlanName)
+	restoreNamespace := determineDestNamespaceName(false, env.namespace)
+	deferCleanup(func() {
+		_, _, _ = env.client.RestoreJobTagApi.DeleteRestoreJob(ctx, env.tenant, restoreJobName)
+		_, _, _ = env.client.RestorePlanTagApi.DeleteRestorePlan(ctx, env.tenant, restorePlanName)
+		k8sClient, dynamicClient, err := newK8sClientFromCluster(env.client, env.tenant, env.cluster.Metadata.Name)
+		if err == nil {
+			_ = deleteNamespace(k8sClient, dynamicClient, restoreNamespace, true)
+		}
+	})
+
+	mapping := fmt.Sprintf("%s:%s", env.namespace, restoreNamespace)
+	restorePlan := newRestorePlan(env.tenant, restorePlanName, backupPlanName, env.cluster.Metadata.Name, []string{mapping})
+	_, _, err := env.client.RestorePlanTagApi.CreateRestorePlan(ctx, env.tenant, restorePlan)
+	Expect(err).ShouldNot(HaveOccurred())
+	Eventually(restorePlanGetter(env.client, env.tenant, restorePlanName), restorePlanReadyTimeout(), pollInterval()).Should(
+		And(BeReadyPlan(), HaveNamespaceMapping(env.namespace, restoreNamespace)))
+	restoreJob := newRestoreJob(env.tenant, restoreJobName, restorePlanName, backupJobName)
+	_, _, err = env.client.RestoreJobTagApi.CreateRestoreJob(ctx, env.tenant, restoreJob)
+	Expect(err).ShouldNot(HaveOccurred())
+	MyBy(fmt.Sprintf("restore job %s created from backup job %s", restoreJobName, backupJobName))
+	return restorePlanName, restoreJobName
+}
+
+var _ = Describe("cascade deletion", func() {
+	var env *testEnv
+
+	BeforeEach(func() {
+		if !*argCascadeTestEnabled {
+			Skip("cascade deletion tests are disabled, enable them with -jibu-cascade-test-enabled")
+		}
+		env = getTestEnv()
+	})
+
+	It("should keep the jobs of a deleted backup plan", func() {
+		f := getOrphanFixture(env)
+		job, _, err := env.client.BackupJobTagApi.GetBackupJob(ctx, env.tenant, f.backupJobName)
+		Expect(err).ShouldNot(HaveOccurred(), "backup job %s is deleted together with its plan", f.backupJobName)
+		Expect(job).Should(HaveJobPhase(JobPhaseCompleted))
+
+		orphaned, err := pickOneJobOfBackupPlan(env.client, env.tenant, f.backupPlanName, 0)
+		Expect(err).ShouldNot(HaveOccurred(), "backup jobs of the deleted plan %s are not listed", f.backupPlanName)
+		Expect(orphaned.Metadata.Name).Should(Equal(f.backupJobName))
+	})
+
+	It("should restore from a job whose backup plan is deleted", func() {
+		f := getOrphanFixture(env)
+		_, restoreJobName := restoreFrom(env, f.backupPlanName, f.backupJobName)
+		MyBy(fmt.Sprintf("restore job should complete in %v", restoreJobFinishedTimeout()))
+		waitRestoreJobComplete(env.client, env.tenant, restoreJobName)
+	})
+
+	It("should reject deleting a restore plan with a restore job in progress", func() {
+		f := getOrphanFixture(env)
+		restorePlanName, restoreJobName := restoreFrom(env, f.backupPlanName, f.backupJobName)
+
+		MyBy(fmt.Sprintf("wait for restore job %s to be in progress", restoreJobName))
+		// polled every second, as the job may not stay in progress for long
+		jobInProgressCondFunc := func() (bool, error) {
+			job, _, err := env.client.RestoreJobTagApi.GetRestoreJob(ctx, env.tenant, restoreJobName)
+			if err != nil {
+				return false, err
+			}
+			phase := job.Status.Phase
+			if isJobStopped(phase) {
+				return false, fmt.Errorf("restore job %s is %s before its plan could be deleted", restoreJobName, phase)
+			}
+			return phase != "" && phase != string(JobPhaseNotStarted), nil
+		}
+		err := wait.Poll(time.Second, restoreJobStartTimeout, jobInProgressCondFunc)
+		Expect(err).ShouldNot(HaveOccurred(), "restore job %s is not in progress in %v", restoreJobName, restoreJobStartTimeout)
+
+		MyBy(fmt.Sprintf("delete restore plan %s", restorePlanName))
+		_, resp, err := env.client.RestorePlanTagApi.DeleteRestorePlan(ctx, env.tenant, restorePlanName)
+		Expect(err).Should(HaveOccurred(), "restore plan %s is deleted while its job is in progress", restorePlanName)
+		Expect(apiStatusCode(resp)).Should(Equal(http.StatusConflict), "unexpected status code, body: %s", apiErrorBody(err))
+
+		MyBy("the restore job should keep running to completion")
+		waitRestoreJobComplete(env.client, env.tenant, restoreJobName)
+
+		MyBy(fmt.Sprintf("restore plan %s can be deleted now", restorePlanName))
+		_, _, err = env.client.RestorePlanTagApi.DeleteRestorePlan(ctx, env.tenant, restorePlanName)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+})
@@ -0,0 +1,185 @@
+package jibu
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const (
+	flagPrefix = "jibu-"
+	envPrefix  = "JIBU_"
+)
+
+// configSource tells where the effective value of an option comes from, by precedence: flag > env > file > default
+type configSource string
+
+const (
+	configSourceFlag    configSource = "flag"
+	configSourceEnv     configSource = "env"
+	configSourceFile    configSource = "file"
+	configSourceDefault configSource = "default"
+)
+
+var config struct {
+	sync.Once
+	sources map[string]configSource
+	err     error
+}
+
+// envName returns the environment variable of the flag, e.g. JIBU_API_ENDPOINT for jibu-api-endpoint
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(strings.TrimPrefix(flagName, flagPrefix), "-", "_"))
+}
+
+// parseFlags parses the command line, then fills the jibu flags which are not set on it from the JIBU_* environment
+// variables and the config file, once. The error is reported by validateFlags along with the others.
+func parseFlags() error {
+	config.Do(func() {
+		flag.Parse()
+		config.sources = make(map[string]configSource)
+		flag.Visit(func(f *flag.Flag) {
+			config.sources[f.Name] = configSourceFlag
+		})
+
+		path := *argConfigFile
+		if config.sources[argConfigFileName] != configSourceFlag {
+			if env, ok := os.LookupEnv(envName(argConfigFileName)); ok {
+				path = env
+			}
+		}
+		fileValues, err := loadConfigFile(path)
+		if err != nil {
+			config.err = err
+			return
+		}
+
+		var errs []string
+		flag.VisitAll(func(f *flag.Flag) {
+			if !strings.HasPrefix(f.Name, flagPrefix) || f.Name == argConfigFileName || config.sources[f.Name] == configSourceFlag {
+				return
+			}
+			source, value := configSourceDefault, ""
+			if env, ok := os.LookupEnv(envName(f.Name)); ok {
+				source, value = configSourceEnv, env
+			} else if v, ok := fileValues[strings.TrimPrefix(f.Name, flagPrefix)]; ok {
+				source, value = configSourceFile, v
+			}
+			config.sources[f.Name] = source
+			if source == configSourceDefault {
+				return
+			}
+			values := []string{value}
+			if _, repeated := f.Value.(*repeatedFlag); repeated {
+				values = strings.Split(value, "\n")
+			}
+			for _, v := range values {
+				if err := f.Value.Set(v); err != nil {
+					errs = append(errs, fmt.Sprintf("invalid %s from %s: %v", f.Name, source, err))
+				}
+			}
+		})
+		if len(errs) != 0 {
+			config.err = fmt.Errorf("%s", strings.Join(errs, "; "))
+		}
+	})
+	return config.err
+}
+
+// loadConfigFile reads a yaml or json file of options keyed by the flag names with or without the jibu- prefix,
+// lists are joined by comma, or by newline for the repeated flags, and maps are turned into key=value pairs,
+// e.g. for jibu-cluster-kubeconfigs
+func loadConfigFile(path string) (map[string]string, error) {
+	values := make(map[string]string)
+	if path == "" {
+		return values, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+	// values are kept raw and decoded with UseNumber, so numeric ids like the tenant don't lose precision
+	raw := make(map[string]json.RawMessage)
+	if err = yaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096).Decode(&raw); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to decode config file %s: %v", path, err)
+	}
+
+	var unknown []string
+	for key, rawValue := range raw {
+		name := flagPrefix + strings.TrimPrefix(key, flagPrefix)
+		f := flag.Lookup(name)
+		if f == nil || name == argConfigFileName {
+			unknown = append(unknown, key)
+			continue
+		}
+		var v interface{}
+		decoder := json.NewDecoder(bytes.NewReader(rawValue))
+		decoder.UseNumber()
+		if err = decoder.Decode(&v); err != nil {
+			return nil, fmt.Errorf("invalid option %s in config file %s: %v", key, path, err)
+		}
+		separator := ","
+		if _, repeated := f.Value.(*repeatedFlag); repeated {
+			separator = "\n"
+		}
+		values[strings.TrimPrefix(name, flagPrefix)] = configValue(v, separator)
+	}
+	if len(unknown) != 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown options in config file %s: %s", path, strings.Join(unknown, ", "))
+	}
+	return values, nil
+}
+
+func configValue(v interface{}, separator string) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case []interface{}:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			items = append(items, configValue(item, ","))
+		}
+		return strings.Join(items, separator)
+	case map[string]interface{}:
+		pairs := make([]string, 0, len(value))
+		for k, item := range value {
+			pairs = append(pairs, k+"="+configValue(item, ","))
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ",")
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// effectiveConfig returns a table of the jibu flags with their redacted values and where they come from
+func effectiveConfig() string {
+	buf := &bytes.Buffer{}
+	w := tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "OPTION\tVALUE\tSOURCE")
+	flag.VisitAll(func(f *flag.Flag) {
+		if !strings.HasPrefix(f.Name, flagPrefix) {
+			return
+		}
+		source := config.sources[f.Name]
+		if source == "" {
+			source = configSourceDefault
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", f.Name, redactedFlagValue(f.Name, f.Value.String()), source)
+	})
+	_ = w.Flush()
+	return buf.String()
+}
@@ -0,0 +1,182 @@
+package jibu
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	crdFixtureGroup   = "crd.jibutest.io"
+	crdFixtureVersion = "v1"
+	crdFixtureKind    = "Gadget"
+	crdFixturePlural  = "gadgets"
+	crdFixtureName    = crdFixturePlural + "." + crdFixtureGroup
+)
+
+var crdFixtureGVR = schema.GroupVersionResource{Group: crdFixtureGroup, Version: crdFixtureVersion, Resource: crdFixturePlural}
+
+func crdFixtureDefinition() *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apiextensions.k8s.io/v1",
+		"kind":       "CustomResourceDefinition",
+		"metadata":   map[string]interface{}{"name": crdFixtureName},
+		"spec": map[string]interface{}{
+			"group": crdFixtureGroup,
+			"scope": "Namespaced",
+			"names": map[string]interface{}{
+				"plural":   crdFixturePlural,
+				"singular": "gadget",
+				"kind":     crdFixtureKind,
+			},
+			"versions": []interface{}{
+				map[string]interface{}{
+					"name":    crdFixtureVersion,
+					"served":  true,
+					"storage": true,
+					"subresources": map[string]interface{}{
+						"status": map[string]interface{}{},
+					},
+					"schema": map[string]interface{}{
+						"openAPIV3Schema": map[string]interface{}{
+							"type": "object",
+							"properties": map[string]interface{}{
+								"spec":   map[string]interface{}{"type": "object", "x-kubernetes-preserve-unknown-fields": true},
+								"status": map[string]interface{}{"type": "object", "x-kubernetes-preserve-unknown-fields": true},
+							},
+						},
+					},
+				},
+			},
+		},
+	}}
+}
+
+func newGadget(name string, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": crdFixtureGroup + "/" + crdFixtureVersion,
+		"kind":       crdFixtureKind,
+		"metadata": map[string]interface{}{
+			"name":   name,
+			"labels": map[string]interface{}{"app.kubernetes.io/part-of": "jibutest"},
+		},
+		"spec": spec,
+	}}
+}
+
+// deployCRDFixture installs the test CRD and creates a plain instance, an instance with status
+// and an owner with a child referring to it, the created instances are returned for later comparison
+func deployCRDFixture(dynamicClient dynamic.Interface, namespace string) ([]*unstructured.Unstructured, error) {
+	_, err := dynamicClient.Resource(crdGVR).Create(ctx, crdFixtureDefinition(), v1.CreateOptions{})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return nil, err
+	}
+	if err = waitCRDEstablished(dynamicClient, crdFixtureName); err != nil {
+		return nil, err
+	}
+
+	ri := dynamicClient.Resource(crdFixtureGVR).Namespace(namespace)
+	plain, err := ri.Create(ctx, newGadget("gadget-plain", map[string]interface{}{"size": int64(1)}), v1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	withStatus, err := ri.Create(ctx, newGadget("gadget-status", map[string]interface{}{"size": int64(2)}), v1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	withStatus.Object["status"] = map[string]interface{}{"phase": "Ready", "observedSize": int64(2)}
+	withStatus, err = ri.UpdateStatus(ctx, withStatus, v1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	owner, err := ri.Create(ctx, newGadget("gadget-owner", map[string]interface{}{"size": int64(3)}), v1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	child := newGadget("gadget-child", map[string]interface{}{"size": int64(4)})
+	child.SetOwnerReferences([]v1.OwnerReference{
+		{
+			APIVersion: owner.GetAPIVersion(),
+			Kind:       owner.GetKind(),
+			Name:       owner.GetName(),
+			UID:        owner.GetUID(),
+		},
+	})
+	child, err = ri.Create(ctx, child, v1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return []*unstructured.Unstructured{plain, withStatus, owner, child}, nil
+}
+
+// deleteCRDFixture deletes the test CRD together with all its instances
+func deleteCRDFixture(dynamicClient dynamic.Interface) error {
+	err := dynamicClient.Resource(crdGVR).Delete(ctx, crdFixtureName, v1.DeleteOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	crdGoneCondFunc := func() (bool, error) {
+		_, err := dynamicClient.Resource(crdGVR).Get(ctx, crdFixtureName, v1.GetOptions{})
+		if err != nil && errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	return wait.Poll(pollInterval(), 2*time.Minute, crdGoneCondFunc)
+}
+
+// verifyRestoredCRDFixture checks the restored instances carry the same labels, spec and status as the original ones,
+// and the owner references point to the restored owners
+func verifyRestoredCRDFixture(dynamicClient dynamic.Interface, namespace string, expected []*unstructured.Unstructured) error {
+	if _, err := dynamicClient.Resource(crdGVR).Get(ctx, crdFixtureName, v1.GetOptions{}); err != nil {
+		return fmt.Errorf("CRD %s is not restored: %v", crdFixtureName, err)
+	}
+
+	ri := dynamicClient.Resource(crdFixtureGVR).Namespace(namespace)
+	restored := make(map[string]*unstructured.Unstructured)
+	for _, e := range expected {
+		r, err := ri.Get(ctx, e.GetName(), v1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("%s %s is not restored in namespace %s: %v", crdFixtureKind, e.GetName(), namespace, err)
+		}
+		if !reflect.DeepEqual(r.GetLabels(), e.GetLabels()) {
+			return fmt.Errorf("%s %s labels mismatch, expected %v, got %v", crdFixtureKind, e.GetName(), e.GetLabels(), r.GetLabels())
+		}
+		for _, field := range []string{"spec", "status"} {
+			if !reflect.DeepEqual(r.Object[field], e.Object[field]) {
+				return fmt.Errorf("%s %s %s mismatch, expected %v, got %v", crdFixtureKind, e.GetName(), field, e.Object[field], r.Object[field])
+			}
+		}
+		restored[r.GetName()] = r
+	}
+
+	for _, e := range expected {
+		r := restored[e.GetName()]
+		if len(r.GetOwnerReferences()) != len(e.GetOwnerReferences()) {
+			return fmt.Errorf("%s %s owner references mismatch, expected %v, got %v", crdFixtureKind, e.GetName(), e.GetOwnerReferences(), r.GetOwnerReferences())
+		}
+		for _, ref := range r.GetOwnerReferences() {
+			owner, ok := restored[ref.Name]
+			if !ok || ref.Kind != owner.GetKind() {
+				return fmt.Errorf("%s %s refers to owner %s %s which is not restored", crdFixtureKind, e.GetName(), ref.Kind, ref.Name)
+			}
+			if ref.UID != owner.GetUID() {
+				return fmt.Errorf("%s %s refers to owner uid %s, expected the restored owner uid %s", crdFixtureKind, e.GetName(), ref.UID, owner.GetUID())
+			}
+		}
+	}
+	return nil
+}
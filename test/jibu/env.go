@@ -0,0 +1,81 @@
+package jibu
+
+import (
+	"sync"
+
+	swagger "github.com/jibutech/backup-saas-client"
+
+	. "github.com/onsi/gomega"
+)
+
+// testEnv is shared by the specs other than the main backup and restore one,
+// it's resolved from the flags on first use, so it must be used within a running spec
+type testEnv struct {
+	client    *swagger.APIClient
+	endpoint  string
+	tenant    string
+	cluster   *swagger.V1alpha1Cluster
+	storage   *swagger.V1alpha1Storage
+	namespace string
+}
+
+var (
+	sharedEnv     *testEnv
+	sharedEnvLock sync.Mutex
+)
+
+func getTestEnv() *testEnv {
+	sharedEnvLock.Lock()
+	defer sharedEnvLock.Unlock()
+	if sharedEnv != nil {
+		return sharedEnv
+	}
+
+	env := &testEnv{
+		client:   newJibuClient(*argJibuAPIEndpoint),
+		endpoint: *argJibuAPIEndpoint,
+		tenant:   *argTenant,
+	}
+	nsFilter, err := newNamespaceFilterFromFlags()
+	Expect(err).ShouldNot(HaveOccurred())
+	clFilter, err := newClusterFilterFromFlags()
+	Expect(err).ShouldNot(HaveOccurred())
+	stFilter, err := newStorageFilterFromFlags()
+	Expect(err).ShouldNot(HaveOccurred())
+
+	env.cluster = pickOneCluster(env.client, env.tenant, ClusterRoleBackup, *argBackupCluster, clFilter, PickMode(*argPickMode), *argPickStateFile)
+	env.storage = pickOneStorage(env.client, env.tenant, *argStorage, stFilter, PickMode(*argPickMode), *argPickStateFile)
+	env.namespace = pickOneNamespace(env.client, env.tenant, env.cluster.Metadata.Name, *argBackupNamespace, nsFilter).Metadata.Name
+	startCRWatch(env.client, env.tenant, env.cluster.Metadata.Name)
+	log.with(logKeyCluster, env.cluster.Metadata.Name, logKeyNamespace, env.namespace).info("test env resolved", "tenant", env.tenant, "storage", env.storage.Metadata.Name)
+
+	sharedEnv = env
+	return sharedEnv
+}
+
+func newJibuClient(endpoint string) *swagger.APIClient {
+	jibuConf := swagger.NewConfiguration()
+	jibuConf.BasePath = endpoint
+	return swagger.NewAPIClient(jibuConf)
+}
+
+var cleanups struct {
+	sync.Mutex
+	funcs []func()
+}
+
+// deferCleanup registers a function to run after the whole suite, in reverse order of registration
+func deferCleanup(f func()) {
+	cleanups.Lock()
+	defer cleanups.Unlock()
+	cleanups.funcs = append(cleanups.funcs, f)
+}
+
+func runCleanups() {
+	cleanups.Lock()
+	defer cleanups.Unlock()
+	for i := len(cleanups.funcs) - 1; i >= 0; i-- {
+		cleanups.funcs[i]()
+	}
+	cleanups.funcs = nil
+}
@@ -0,0 +1,275 @@
+package jibu
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+)
+
+// progressKeywords pick the fields of a status that tell the progress of a job, e.g. progress, totalSize, itemsBackedUp
+var progressKeywords = []string{"progress", "size", "bytes", "items", "percent"}
+
+// phaseEvent is emitted each time a plan or a job is seen in a new phase or with new progress
+type phaseEvent struct {
+	Time          time.Time              `json:"time"`
+	Scenario      string                 `json:"scenario,omitempty"`
+	Kind          string                 `json:"kind"`
+	Name          string                 `json:"name"`
+	Phase         string                 `json:"phase"`
+	PreviousPhase string                 `json:"previousPhase,omitempty"`
+	Message       string                 `json:"message,omitempty"`
+	Progress      map[string]interface{} `json:"progress,omitempty"`
+	// Elapsed is the time since the resource was first observed
+	Elapsed time.Duration `json:"elapsed"`
+}
+
+func (e phaseEvent) String() string {
+	text := fmt.Sprintf("%s %s: %s after %v", e.Kind, e.Name, e.Phase, e.Elapsed.Round(time.Second))
+	if e.PreviousPhase != "" && e.PreviousPhase != e.Phase {
+		text += fmt.Sprintf(", was %s", e.PreviousPhase)
+	}
+	if len(e.Progress) != 0 {
+		text += fmt.Sprintf(", progress: %s", formatProgress(e.Progress))
+	}
+	if e.Message != "" {
+		text += fmt.Sprintf(", message: %s", e.Message)
+	}
+	return text
+}
+
+// phaseEventHandler is called with every event, it must not block
+type phaseEventHandler func(e phaseEvent)
+
+type watchState struct {
+	first    time.Time
+	lastLine time.Time
+	phase    string
+	progress string
+}
+
+// phaseWatcher turns the observations of the poll helpers and getters into events
+var phaseWatcher = struct {
+	sync.Mutex
+	nextID   int
+	handlers map[int]phaseEventHandler
+	states   map[string]*watchState
+	events   []phaseEvent
+}{
+	handlers: make(map[int]phaseEventHandler),
+	states:   make(map[string]*watchState),
+}
+
+// onPhaseEvent registers a handler for all the following events, the returned function unregisters it
+func onPhaseEvent(h phaseEventHandler) func() {
+	phaseWatcher.Lock()
+	defer phaseWatcher.Unlock()
+	id := phaseWatcher.nextID
+	phaseWatcher.nextID++
+	phaseWatcher.handlers[id] = h
+	return func() {
+		phaseWatcher.Lock()
+		defer phaseWatcher.Unlock()
+		delete(phaseWatcher.handlers, id)
+	}
+}
+
+// observePhase records what is seen of a plan or a job, an event is emitted if its phase or progress changed.
+// Without a change, a progress line is still printed every progressLineInterval so that long waits are not silent.
+func observePhase(kind string, name string, phase string, message string, status interface{}) {
+	observeScenarioPhase(currentScenario(), kind, name, phase, message, status)
+}
+
+// observeScenarioPhase is observePhase for the goroutines other than the spec's, which pass the scenario explicitly
+func observeScenarioPhase(scenario string, kind string, name string, phase string, message string, status interface{}) {
+	recordPhase(kind, name, phase)
+	progress := progressFields(status)
+	progressText := formatProgress(progress)
+	now := time.Now()
+
+	phaseWatcher.Lock()
+	key := kind + "/" + name
+	state, seen := phaseWatcher.states[key]
+	if !seen {
+		state = &watchState{first: now}
+		phaseWatcher.states[key] = state
+	}
+	if seen && state.phase == phase && state.progress == progressText {
+		printLine := now.Sub(state.lastLine) >= progressLineInterval
+		if printLine {
+			state.lastLine = now
+		}
+		phaseWatcher.Unlock()
+		if printLine {
+			scenarioLogger(scenario, kind, name).info(fmt.Sprintf("still %s after %v", phase, now.Sub(state.first).Round(time.Second)))
+		}
+		return
+	}
+
+	e := phaseEvent{
+		Time:          now,
+		Scenario:      scenario,
+		Kind:          kind,
+		Name:          name,
+		Phase:         phase,
+		PreviousPhase: state.phase,
+		Message:       message,
+		Progress:      progress,
+		Elapsed:       now.Sub(state.first),
+	}
+	state.phase, state.progress, state.lastLine = phase, progressText, now
+	phaseWatcher.events = append(phaseWatcher.events, e)
+	handlers := make([]phaseEventHandler, 0, len(phaseWatcher.handlers))
+	ids := make([]int, 0, len(phaseWatcher.handlers))
+	for id := range phaseWatcher.handlers {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		handlers = append(handlers, phaseWatcher.handlers[id])
+	}
+	phaseWatcher.Unlock()
+
+	for _, h := range handlers {
+		h(e)
+	}
+}
+
+// phaseEventsSince returns the events emitted after t
+func phaseEventsSince(t time.Time) []phaseEvent {
+	phaseWatcher.Lock()
+	defer phaseWatcher.Unlock()
+	var events []phaseEvent
+	for _, e := range phaseWatcher.events {
+		if !e.Time.Before(t) {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// dropPhaseEventsBefore forgets the events emitted before t, so that only the current spec's events are kept
+func dropPhaseEventsBefore(t time.Time) {
+	phaseWatcher.Lock()
+	defer phaseWatcher.Unlock()
+	var kept []phaseEvent
+	for _, e := range phaseWatcher.events {
+		if !e.Time.Before(t) {
+			kept = append(kept, e)
+		}
+	}
+	phaseWatcher.events = kept
+}
+
+// progressFields returns the fields of the status whose name contains any of progressKeywords
+func progressFields(status interface{}) map[string]interface{} {
+	if status == nil {
+		return nil
+	}
+	raw, err := json.Marshal(status)
+	if err != nil {
+		return nil
+	}
+	fields := make(map[string]interface{})
+	if err = json.Unmarshal(raw, &fields); err != nil {
+		return nil
+	}
+	progress := make(map[string]interface{})
+	for k, v := range fields {
+		lower := strings.ToLower(k)
+		for _, keyword := range progressKeywords {
+			if strings.Contains(lower, keyword) {
+				progress[k] = v
+				break
+			}
+		}
+	}
+	if len(progress) == 0 {
+		return nil
+	}
+	return progress
+}
+
+func formatProgress(progress map[string]interface{}) string {
+	keys := make([]string, 0, len(progress))
+	for k := range progress {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	items := make([]string, 0, len(keys))
+	for _, k := range keys {
+		items = append(items, fmt.Sprintf("%s=%v", k, progress[k]))
+	}
+	return strings.Join(items, " ")
+}
+
+// printPhaseEvent is the handler printing the live progress lines
+func printPhaseEvent(e phaseEvent) {
+	l := scenarioLogger(e.Scenario, e.Kind, e.Name)
+	if e.Phase == string(JobPhaseFailed) || e.Phase == string(PhaseError) {
+		l.warn(e.String())
+		return
+	}
+	l.info(e.String())
+}
+
+var (
+	eventsFileLock sync.Mutex
+	// eventsFileWarnOnce logs the first failure to write the events file, the later ones would only repeat it
+	eventsFileWarnOnce sync.Once
+)
+
+func warnEventsFile(err error) {
+	eventsFileWarnOnce.Do(func() {
+		log.warn("failed to write the phase events, later failures are not logged", "file", *argEventsFile, "error", err)
+	})
+}
+
+// appendPhaseEvent is the handler writing the events into -jibu-events-file, one json object per line
+func appendPhaseEvent(e phaseEvent) {
+	if *argEventsFile == "" {
+		return
+	}
+	eventsFileLock.Lock()
+	defer eventsFileLock.Unlock()
+	f, err := os.OpenFile(*argEventsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		warnEventsFile(err)
+		return
+	}
+	defer f.Close()
+	e.Message = redactText(e.Message)
+	if err = json.NewEncoder(f).Encode(e); err != nil {
+		warnEventsFile(err)
+	}
+}
+
+func init() {
+	onPhaseEvent(printPhaseEvent)
+	onPhaseEvent(appendPhaseEvent)
+}
+
+var specStarted time.Time
+
+var _ = BeforeEach(func() {
+	specStarted = time.Now()
+	dropPhaseEventsBefore(specStarted)
+})
+
+// the events of a failed spec are printed as its diagnostics
+var _ = AfterEach(func() {
+	if !CurrentGinkgoTestDescription().Failed {
+		return
+	}
+	events := phaseEventsSince(specStarted)
+	lines := make([]string, 0, len(events))
+	for _, e := range events {
+		lines = append(lines, fmt.Sprintf("  %s %s", e.Time.Format("15:04:05"), e))
+	}
+	log.error(fmt.Sprintf("spec failed, %d phase events since it started:\n%s", len(events), strings.Join(lines, "\n")))
+})
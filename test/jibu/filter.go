@@ -0,0 +1,244 @@
+package jibu
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/elliotchance/pie/pie"
+	swagger "github.com/jibutech/backup-saas-client"
+	"github.com/stoneshi-yunify/jibutest/pkg/utils/match"
+)
+
+// namespaceFilter decides which namespaces can be picked for backup
+type namespaceFilter struct {
+	include      match.Patterns
+	exclude      match.Patterns
+	selector     labels.Selector
+	minBoundPVCs int
+	minPods      int
+}
+
+func newNamespaceFilterFromFlags() (*namespaceFilter, error) {
+	f := &namespaceFilter{
+		minBoundPVCs: *argNamespaceMinBoundPVCs,
+		minPods:      *argNamespaceMinPods,
+	}
+	var err error
+	if f.include, err = match.ParsePatterns(*argNamespaceInclude); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = match.ParsePatterns(*argExcludeNamespaces + "," + *argNamespaceExclude); err != nil {
+		return nil, err
+	}
+	if f.selector, err = labels.Parse(*argNamespaceSelector); err != nil {
+		return nil, fmt.Errorf("invalid namespace selector %s: %v", *argNamespaceSelector, err)
+	}
+	if f.minBoundPVCs < 0 || f.minPods < 0 {
+		return nil, fmt.Errorf("namespace content requirements must not be negative")
+	}
+	return f, nil
+}
+
+// needsContent returns true if the filter has to look into the namespace through the cluster kubeconfig
+func (f *namespaceFilter) needsContent() bool {
+	return f.minBoundPVCs > 0 || f.minPods > 0
+}
+
+func (f *namespaceFilter) matchMeta(ns *swagger.V1Namespace) bool {
+	name := ns.Metadata.Name
+	if !f.include.Empty() && !f.include.Match(name) {
+		return false
+	}
+	if f.exclude.Match(name) {
+		return false
+	}
+	return f.selector.Matches(labels.Set(ns.Metadata.Labels))
+}
+
+func (f *namespaceFilter) matchContent(kubeClient kubernetes.Interface, namespace string) (bool, error) {
+	if f.minBoundPVCs > 0 {
+		pvcs, err := kubeClient.CoreV1().PersistentVolumeClaims(namespace).List(ctx, v1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		bound := 0
+		for _, pvc := range pvcs.Items {
+			if pvc.Status.Phase == corev1.ClaimBound {
+				bound++
+			}
+		}
+		if bound < f.minBoundPVCs {
+			return false, nil
+		}
+	}
+	if f.minPods > 0 {
+		pods, err := kubeClient.CoreV1().Pods(namespace).List(ctx, v1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		running := 0
+		for _, pod := range pods.Items {
+			if pod.Status.Phase == corev1.PodRunning {
+				running++
+			}
+		}
+		if running < f.minPods {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// filterNamespaces returns the namespaces passing the filter sorted by name,
+// kubeClient is only used if the filter has content requirements
+func filterNamespaces(namespaces []swagger.V1Namespace, f *namespaceFilter, kubeClient kubernetes.Interface) ([]swagger.V1Namespace, error) {
+	var candidates []swagger.V1Namespace
+	for i := range namespaces {
+		ns := &namespaces[i]
+		if !f.matchMeta(ns) {
+			continue
+		}
+		if f.needsContent() {
+			ok, err := f.matchContent(kubeClient, ns.Metadata.Name)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		candidates = append(candidates, *ns)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Metadata.Name < candidates[j].Metadata.Name
+	})
+	return candidates, nil
+}
+
+// clusterFilter decides which clusters can be picked for backup and restore
+type clusterFilter struct {
+	phase       string
+	selector    labels.Selector
+	displayName match.Patterns
+	k8sVersion  match.VersionConstraints
+}
+
+func newClusterFilterFromFlags() (*clusterFilter, error) {
+	f := &clusterFilter{phase: *argClusterPhase}
+	var err error
+	if f.selector, err = labels.Parse(*argClusterSelector); err != nil {
+		return nil, fmt.Errorf("invalid cluster selector %s: %v", *argClusterSelector, err)
+	}
+	if f.displayName, err = match.ParsePatterns(*argClusterNamePattern); err != nil {
+		return nil, err
+	}
+	if f.k8sVersion, err = match.ParseVersionConstraints(*argClusterK8sVersion); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *clusterFilter) matchMeta(c *swagger.V1alpha1Cluster) bool {
+	if f.phase != "" && c.Status.Phase != f.phase {
+		return false
+	}
+	if !f.displayName.Empty() && !f.displayName.Match(c.Spec.DisplayName) {
+		return false
+	}
+	return f.selector.Matches(labels.Set(c.Metadata.Labels))
+}
+
+// filterClusters returns the clusters passing the filter sorted by name,
+// the kubernetes version is asked from the cluster itself, so k8sClientFunc is only used if there is a version constraint,
+// a cluster which can't be reached for its version is skipped with a warning
+func filterClusters(clusters []swagger.V1alpha1Cluster, f *clusterFilter, k8sClientFunc func(cluster string) (kubernetes.Interface, error)) ([]swagger.V1alpha1Cluster, error) {
+	var candidates []swagger.V1alpha1Cluster
+	for i := range clusters {
+		c := &clusters[i]
+		if !f.matchMeta(c) {
+			continue
+		}
+		if !f.k8sVersion.Empty() {
+			k8sClient, err := k8sClientFunc(c.Metadata.Name)
+			if err != nil {
+				log.with(logKeyCluster, c.Metadata.Name).warn("skip cluster, failed to get its kubeconfig", "error", err)
+				continue
+			}
+			serverVersion, err := k8sClient.Discovery().ServerVersion()
+			if err != nil {
+				log.with(logKeyCluster, c.Metadata.Name).warn("skip cluster, failed to get its kubernetes version", "error", err)
+				continue
+			}
+			ok, err := f.k8sVersion.Match(serverVersion.GitVersion)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		candidates = append(candidates, *c)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Metadata.Name < candidates[j].Metadata.Name
+	})
+	return candidates, nil
+}
+
+// storageFilter decides which storages can be picked for backup
+type storageFilter struct {
+	phase       string
+	selector    labels.Selector
+	displayName match.Patterns
+	types       pie.Strings
+}
+
+func newStorageFilterFromFlags() (*storageFilter, error) {
+	f := &storageFilter{phase: *argStoragePhase}
+	var err error
+	if f.selector, err = labels.Parse(*argStorageSelector); err != nil {
+		return nil, fmt.Errorf("invalid storage selector %s: %v", *argStorageSelector, err)
+	}
+	if f.displayName, err = match.ParsePatterns(*argStorageNamePattern); err != nil {
+		return nil, err
+	}
+	for _, t := range strings.Split(*argStorageType, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			f.types = append(f.types, strings.ToLower(t))
+		}
+	}
+	return f, nil
+}
+
+func (f *storageFilter) match(s *swagger.V1alpha1Storage) bool {
+	if f.phase != "" && s.Status.Phase != f.phase {
+		return false
+	}
+	if !f.displayName.Empty() && !f.displayName.Match(s.Spec.DisplayName) {
+		return false
+	}
+	if len(f.types) != 0 && !f.types.Contains(strings.ToLower(s.Spec.Type)) {
+		return false
+	}
+	return f.selector.Matches(labels.Set(s.Metadata.Labels))
+}
+
+// filterStorages returns the storages passing the filter sorted by name
+func filterStorages(storages []swagger.V1alpha1Storage, f *storageFilter) []swagger.V1alpha1Storage {
+	var candidates []swagger.V1alpha1Storage
+	for i := range storages {
+		if f.match(&storages[i]) {
+			candidates = append(candidates, storages[i])
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Metadata.Name < candidates[j].Metadata.Name
+	})
+	return candidates
+}
@@ -0,0 +1,268 @@
+package jibu
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	generationFixtureName     = "jibutest-generation"
+	generationFixtureReplicas = 2
+	generationKey             = "generation"
+	restoredGenerationPrefix  = "restored-generation="
+	writtenGenerationPrefix   = "written-generation="
+	generationStampLabel      = "jibutest/generation-stamp"
+)
+
+// generationFixtureScript logs the generation found on the volume at startup,
+// then keeps copying the generation from the configmap onto the volume
+const generationFixtureScript = `echo "` + restoredGenerationPrefix + `$(cat /data/generation 2>/dev/null)"
+while true; do
+  g=$(cat /config/generation)
+  if [ "$g" != "$(cat /data/generation 2>/dev/null)" ]; then
+    echo "$g" > /data/generation && sync && echo "` + writtenGenerationPrefix + `$g"
+  fi
+  sleep 2
+done`
+
+// generationStamp records the server time when a generation was written to all the replicas
+type generationStamp struct {
+	generation int
+	at         time.Time
+}
+
+// generationTracker remembers which generation was on the volumes at a given time
+type generationTracker struct {
+	mu     sync.Mutex
+	stamps []generationStamp
+}
+
+func (t *generationTracker) record(generation int, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stamps = append(t.stamps, generationStamp{generation: generation, at: at})
+}
+
+func (t *generationTracker) current() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.stamps) == 0 {
+		return -1
+	}
+	return t.stamps[len(t.stamps)-1].generation
+}
+
+// at returns the latest generation written before the given time
+func (t *generationTracker) at(when time.Time) (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := len(t.stamps) - 1; i >= 0; i-- {
+		if !t.stamps[i].at.After(when) {
+			return t.stamps[i].generation, true
+		}
+	}
+	return 0, false
+}
+
+// deployGenerationFixture deploys a statefulset whose replicas each write the current generation into their own pvc
+func deployGenerationFixture(kubeClient kubernetes.Interface, namespace string) error {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: generationFixtureName},
+		Data:       map[string]string{generationKey: "0"},
+	}
+	if _, err := kubeClient.CoreV1().ConfigMaps(namespace).Create(ctx, cm, v1.CreateOptions{}); err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+
+	replicas := int32(generationFixtureReplicas)
+	labels := map[string]string{"app": generationFixtureName}
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: v1.ObjectMeta{Name: generationFixtureName, Labels: labels},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:    &replicas,
+			ServiceName: generationFixtureName,
+			Selector:    &v1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: v1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:    "app",
+							Image:   "busybox:1.35",
+							Command: []string{"sh", "-c", generationFixtureScript},
+							VolumeMounts: []corev1.VolumeMount{
+								{Name: "data", MountPath: "/data"},
+								{Name: "config", MountPath: "/config"},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "config",
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{
+									LocalObjectReference: corev1.LocalObjectReference{Name: generationFixtureName},
+								},
+							},
+						},
+					},
+				},
+			},
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
+				{
+					ObjectMeta: v1.ObjectMeta{Name: "data"},
+					Spec: corev1.PersistentVolumeClaimSpec{
+						AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+						Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+						},
+					},
+				},
+			},
+		},
+	}
+	if _, err := kubeClient.AppsV1().StatefulSets(namespace).Create(ctx, sts, v1.CreateOptions{}); err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+
+	return waitGenerationFixtureReady(kubeClient, namespace)
+}
+
+func waitGenerationFixtureReady(kubeClient kubernetes.Interface, namespace string) error {
+	fixtureReadyCondFunc := func() (bool, error) {
+		sts, err := kubeClient.AppsV1().StatefulSets(namespace).Get(ctx, generationFixtureName, v1.GetOptions{})
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		return sts.Status.ReadyReplicas == generationFixtureReplicas, nil
+	}
+	return wait.Poll(pollInterval(), workloadReadyTimeout, fixtureReadyCondFunc)
+}
+
+// deleteGenerationFixture deletes the statefulset, its configmaps and pvcs, errors are ignored
+func deleteGenerationFixture(kubeClient kubernetes.Interface, namespace string) {
+	_ = kubeClient.AppsV1().StatefulSets(namespace).Delete(ctx, generationFixtureName, v1.DeleteOptions{})
+	_ = kubeClient.CoreV1().ConfigMaps(namespace).Delete(ctx, generationFixtureName, v1.DeleteOptions{})
+	_ = kubeClient.CoreV1().ConfigMaps(namespace).DeleteCollection(ctx, v1.DeleteOptions{}, v1.ListOptions{LabelSelector: generationStampLabel})
+	for i := 0; i < generationFixtureReplicas; i++ {
+		pvc := fmt.Sprintf("data-%s-%d", generationFixtureName, i)
+		_ = kubeClient.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, pvc, v1.DeleteOptions{})
+	}
+}
+
+// stampGeneration updates the generation and waits for every replica to write it into its pvc,
+// then creates a marker configmap and returns its creation time, so the stamp is timed by the server rather than the
+// local clock. The plan must not create jobs meanwhile, or a job may catch the replicas with different generations.
+func stampGeneration(kubeClient kubernetes.Interface, namespace string, generation int) (time.Time, error) {
+	cm, err := kubeClient.CoreV1().ConfigMaps(namespace).Get(ctx, generationFixtureName, v1.GetOptions{})
+	if err != nil {
+		return time.Time{}, err
+	}
+	cm.Data[generationKey] = strconv.Itoa(generation)
+	if _, err = kubeClient.CoreV1().ConfigMaps(namespace).Update(ctx, cm, v1.UpdateOptions{}); err != nil {
+		return time.Time{}, err
+	}
+
+	generationWrittenCondFunc := func() (bool, error) {
+		for i := 0; i < generationFixtureReplicas; i++ {
+			lines, err := generationFixtureLogs(kubeClient, namespace, i, writtenGenerationPrefix)
+			if err != nil {
+				return false, err
+			}
+			if len(lines) == 0 || lines[len(lines)-1] != strconv.Itoa(generation) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+	// configmap volumes are refreshed by kubelet periodically, which can take more than a minute
+	if err = wait.Poll(pollInterval(), generationWriteTimeout, generationWrittenCondFunc); err != nil {
+		return time.Time{}, err
+	}
+
+	marker := &corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{
+			Name:   fmt.Sprintf("%s-stamp-%d", generationFixtureName, generation),
+			Labels: map[string]string{generationStampLabel: strconv.Itoa(generation)},
+		},
+	}
+	// a marker left by a previous run would carry its creation time
+	_ = kubeClient.CoreV1().ConfigMaps(namespace).Delete(ctx, marker.Name, v1.DeleteOptions{})
+	marker, err = kubeClient.CoreV1().ConfigMaps(namespace).Create(ctx, marker, v1.CreateOptions{})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return marker.CreationTimestamp.Time, nil
+}
+
+// verifyRestoredGeneration checks each restored replica found the expected generation on its volume at startup,
+// an expected generation of -1 means the volumes should have been empty
+func verifyRestoredGeneration(kubeClient kubernetes.Interface, namespace string, expected int) error {
+	if err := waitGenerationFixtureReady(kubeClient, namespace); err != nil {
+		return fmt.Errorf("generation fixture is not ready in namespace %s: %v", namespace, err)
+	}
+	want := ""
+	if expected >= 0 {
+		want = strconv.Itoa(expected)
+	}
+	for i := 0; i < generationFixtureReplicas; i++ {
+		lines, err := generationFixtureLogs(kubeClient, namespace, i, restoredGenerationPrefix)
+		if err != nil {
+			return err
+		}
+		if len(lines) == 0 {
+			return fmt.Errorf("replica %d in namespace %s didn't report its restored generation", i, namespace)
+		}
+		if lines[0] != want {
+			return fmt.Errorf("replica %d in namespace %s restored generation %q, expected %q", i, namespace, lines[0], want)
+		}
+	}
+	return nil
+}
+
+// generationFixtureReplica returns the index of the replica owning the pvc, if it's a pvc of the generation fixture
+func generationFixtureReplica(pvc string) (int, bool) {
+	prefix := "data-" + generationFixtureName + "-"
+	if !strings.HasPrefix(pvc, prefix) {
+		return 0, false
+	}
+	i, err := strconv.Atoi(strings.TrimPrefix(pvc, prefix))
+	if err != nil {
+		return 0, false
+	}
+	return i, true
+}
+
+// generationFixtureLogs returns the values of the log lines with the given prefix of the i-th replica
+func generationFixtureLogs(kubeClient kubernetes.Interface, namespace string, i int, prefix string) ([]string, error) {
+	pod := fmt.Sprintf("%s-%d", generationFixtureName, i)
+	raw, err := kubeClient.CoreV1().Pods(namespace).GetLogs(pod, &corev1.PodLogOptions{Container: "app"}).Do(ctx).Raw()
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, prefix) {
+			values = append(values, strings.TrimPrefix(line, prefix))
+		}
+	}
+	return values, scanner.Err()
+}
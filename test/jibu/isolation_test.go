@@ -0,0 +1,127 @@
+package jibu
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// isolationFixture holds the plan and job created in the owner tenant
+type isolationFixture struct {
+	backupPlanName string
+	backupJobName  string
+}
+
+var isolation *isolationFixture
+
+func getIsolationFixture(env *testEnv) *isolationFixture {
+	if isolation != nil {
+		return isolation
+	}
+
+	f := &isolationFixture{
+		backupPlanName: randomName("isolation"),
+	}
+	f.backupJobName = fmt.Sprintf("%s-job", f.backupPlanName)
+
+	MyBy(fmt.Sprintf("create backup plan %s in tenant %s", f.backupPlanName, env.tenant))
+	backupPlan := newBackupPlan(env.tenant, f.backupPlanName, env.cluster.Metadata.Name, env.storage.Metadata.Name, []string{env.namespace})
+	_, _, err := env.client.BackupPlanTagApi.CreateBackupPlan(ctx, env.tenant, backupPlan)
+	Expect(err).ShouldNot(HaveOccurred())
+	deferCleanup(func() {
+		_, _, _ = env.client.BackupPlanTagApi.DeleteBackupPlan(ctx, env.tenant, f.backupPlanName)
+		_ = deleteJobsOfBackupPlan(env.client, env.tenant, f.backupPlanName)
+	})
+	waitBackupPlanReady(env.client, env.tenant, f.backupPlanName)
+
+	MyBy(fmt.Sprintf("create backup job %s in tenant %s", f.backupJobName, env.tenant))
+	backupJob := newBackupJob(env.tenant, f.backupJobName, f.backupPlanName)
+	_, _, err = env.client.BackupJobTagApi.CreateBackupJob(ctx, env.tenant, backupJob)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	isolation = f
+	return isolation
+}
+
+// expectForbiddenOrNotFound asserts the call is rejected as if the resource doesn't exist or isn't accessible
+func expectForbiddenOrNotFound(resp *http.Response, err error) {
+	Expect(err).Should(HaveOccurred(), "the other tenant's resource is accessible")
+	Expect(apiStatusCode(resp)).Should(BeElementOf(http.StatusForbidden, http.StatusNotFound), "unexpected status code, body: %s", apiErrorBody(err))
+}
+
+// isolationTenantCluster returns a cluster of the isolation tenant, ready ones first, empty if it has none
+func isolationTenantCluster(env *testEnv, tenant string) string {
+	clusterList, _, err := env.client.ClusterApi.ListClusters(ctx, tenant, nil)
+	Expect(err).ShouldNot(HaveOccurred())
+	clusters := clusterList.Items
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return clusters[i].Status.Phase == string(PhaseReady) && clusters[j].Status.Phase != string(PhaseReady)
+	})
+	if len(clusters) == 0 {
+		return ""
+	}
+	return clusters[0].Metadata.Name
+}
+
+var _ = Describe("tenant isolation", func() {
+	var env *testEnv
+	var fixture *isolationFixture
+	var otherTenant string
+
+	BeforeEach(func() {
+		otherTenant = *argIsolationTenant
+		if otherTenant == "" {
+			Skip("tenant isolation tests are disabled, enable them with -jibu-isolation-tenant")
+		}
+		env = getTestEnv()
+		Expect(otherTenant).ShouldNot(Equal(env.tenant), "isolation tenant must differ from the tested tenant")
+		fixture = getIsolationFixture(env)
+	})
+
+	It("should not list the backup plans of another tenant", func() {
+		planList, _, err := env.client.BackupPlanTagApi.ListBackupPlans(ctx, otherTenant, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		for _, p := range planList.Items {
+			Expect(p.Metadata.Name).ShouldNot(Equal(fixture.backupPlanName), "backup plan of tenant %s is listed in tenant %s", env.tenant, otherTenant)
+		}
+	})
+
+	It("should not get the backup plans and jobs of another tenant", func() {
+		_, resp, err := env.client.BackupPlanTagApi.GetBackupPlan(ctx, otherTenant, fixture.backupPlanName)
+		expectForbiddenOrNotFound(resp, err)
+		_, resp, err = env.client.BackupJobTagApi.GetBackupJob(ctx, otherTenant, fixture.backupJobName)
+		expectForbiddenOrNotFound(resp, err)
+	})
+
+	It("should not delete the backup plans of another tenant", func() {
+		_, resp, err := env.client.BackupPlanTagApi.DeleteBackupPlan(ctx, otherTenant, fixture.backupPlanName)
+		expectForbiddenOrNotFound(resp, err)
+
+		MyBy(fmt.Sprintf("backup plan %s should still be intact in tenant %s", fixture.backupPlanName, env.tenant))
+		plan, _, err := env.client.BackupPlanTagApi.GetBackupPlan(ctx, env.tenant, fixture.backupPlanName)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(plan.Status.Phase).ShouldNot(BeElementOf(string(PhaseDeleting), string(PhaseDeleted)))
+	})
+
+	It("should not restore from the backups of another tenant", func() {
+		// the destination cluster belongs to the other tenant, so only the backup plan can be the reason of a rejection
+		cluster := isolationTenantCluster(env, otherTenant)
+		if cluster == "" {
+			Skip(fmt.Sprintf("tenant %s has no cluster to restore to", otherTenant))
+		}
+		name := randomName("isolation-restore")
+		mapping := fmt.Sprintf("%s:%s", env.namespace, determineDestNamespaceName(false, env.namespace))
+		restorePlan := newRestorePlan(otherTenant, name, fixture.backupPlanName, cluster, []string{mapping})
+		_, resp, err := env.client.RestorePlanTagApi.CreateRestorePlan(ctx, otherTenant, restorePlan)
+		if err == nil {
+			_, _, _ = env.client.RestorePlanTagApi.DeleteRestorePlan(ctx, otherTenant, name)
+		}
+		Expect(err).Should(HaveOccurred(), "tenant %s can create a restore plan from the backup of tenant %s", otherTenant, env.tenant)
+		Expect(apiStatusCode(resp)).Should(BeElementOf(http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound), "unexpected status code, body: %s", apiErrorBody(err))
+		Expect(strings.ToLower(apiErrorBody(err))).Should(ContainSubstring("backup"), "restore plan is rejected for another reason than the backup plan")
+	})
+})
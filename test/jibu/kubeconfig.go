@@ -0,0 +1,117 @@
+package jibu
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/antihax/optional"
+	swagger "github.com/jibutech/backup-saas-client"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	. "github.com/onsi/gomega"
+)
+
+// clusterKubeconfig is where the kubeconfig of a jibu cluster is taken from locally,
+// an empty path means the default loading rules(KUBECONFIG or ~/.kube/config), an empty context the current one
+type clusterKubeconfig struct {
+	path    string
+	context string
+}
+
+// parseClusterMapping parses a comma separated list of cluster=value pairs
+func parseClusterMapping(s string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	for _, item := range splitList(s) {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("invalid cluster mapping %s: expected cluster=value", item)
+		}
+		mapping[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return mapping, nil
+}
+
+// localKubeconfigs returns the local kubeconfigs mapped by -jibu-cluster-kubeconfigs and -jibu-cluster-contexts, keyed by cluster id
+func localKubeconfigs() (map[string]clusterKubeconfig, error) {
+	paths, err := parseClusterMapping(*argClusterKubeconfigs)
+	if err != nil {
+		return nil, err
+	}
+	contexts, err := parseClusterMapping(*argClusterContexts)
+	if err != nil {
+		return nil, err
+	}
+
+	kubeconfigs := make(map[string]clusterKubeconfig)
+	for cluster, path := range paths {
+		if _, err = os.Stat(path); err != nil {
+			return nil, fmt.Errorf("invalid kubeconfig of cluster %s: %v", cluster, err)
+		}
+		kubeconfigs[cluster] = clusterKubeconfig{path: path}
+	}
+	for cluster, context := range contexts {
+		kc := kubeconfigs[cluster]
+		kc.context = context
+		kubeconfigs[cluster] = kc
+	}
+	return kubeconfigs, nil
+}
+
+// restConfigForCluster builds the rest config of the cluster from its local kubeconfig if one is mapped,
+// otherwise from the kubeconfig returned by the api, if -jibu-api-kubeconfig-allowed is set
+func restConfigForCluster(jibuClient *swagger.APIClient, tenant string, cluster string) (*rest.Config, error) {
+	kubeconfigs, err := localKubeconfigs()
+	if err != nil {
+		return nil, err
+	}
+	if kc, ok := kubeconfigs[cluster]; ok {
+		rules := clientcmd.NewDefaultClientConfigLoadingRules()
+		rules.ExplicitPath = kc.path
+		overrides := &clientcmd.ConfigOverrides{CurrentContext: kc.context}
+		restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load local kubeconfig of cluster %s: %v", cluster, err)
+		}
+		return restConfig, nil
+	}
+
+	if !*argAPIKubeconfigAllowed {
+		return nil, fmt.Errorf("no local kubeconfig is mapped for cluster %s, and fetching it through the api is not allowed", cluster)
+	}
+	opts := swagger.ClusterApiGetClusterOpts{IncludeKubeconfig: optional.NewString("true")}
+	c, _, err := jibuClient.ClusterApi.GetCluster(ctx, tenant, cluster, &opts)
+	if err != nil {
+		return nil, err
+	}
+	clientConfig, err := clientcmd.NewClientConfigFromBytes([]byte(c.Spec.Kubeconfig))
+	if err != nil {
+		return nil, err
+	}
+	return clientConfig.ClientConfig()
+}
+
+func getK8sClientFromCluster(jibuClient *swagger.APIClient, tenant string, cluster string) (kubernetes.Interface, dynamic.Interface) {
+	kubeClient, dynamicClient, err := newK8sClientFromCluster(jibuClient, tenant, cluster)
+	Expect(err).ShouldNot(HaveOccurred())
+	return kubeClient, dynamicClient
+}
+
+func newK8sClientFromCluster(jibuClient *swagger.APIClient, tenant string, cluster string) (kubernetes.Interface, dynamic.Interface, error) {
+	restClient, err := restConfigForCluster(jibuClient, tenant, cluster)
+	if err != nil {
+		return nil, nil, err
+	}
+	kubeClient, err := kubernetes.NewForConfig(restClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(restClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	return kubeClient, dynamicClient, nil
+}
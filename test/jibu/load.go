@@ -0,0 +1,126 @@
+package jibu
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	loadOpCreateBackupPlan  = "create backup plan"
+	loadOpBackupPlanReady   = "backup plan ready"
+	loadOpCreateBackupJob   = "create backup job"
+	loadOpBackupJobComplete = "backup job complete"
+)
+
+// loadOps is the order the operations are reported in
+var loadOps = []string{loadOpCreateBackupPlan, loadOpBackupPlanReady, loadOpCreateBackupJob, loadOpBackupJobComplete}
+
+// loadStats collects the outcome and latency of every operation of the load test, it's safe for concurrent use,
+// errors of the api calls are kept apart from the plans and jobs which timed out or ended in a wrong phase
+type loadStats struct {
+	mu        sync.Mutex
+	calls     map[string]int
+	errors    map[string][]error
+	failures  map[string][]error
+	latencies map[string][]time.Duration
+}
+
+func newLoadStats() *loadStats {
+	return &loadStats{
+		calls:     make(map[string]int),
+		errors:    make(map[string][]error),
+		failures:  make(map[string][]error),
+		latencies: make(map[string][]time.Duration),
+	}
+}
+
+// record counts one call of the operation, the latency is only kept for successful calls
+func (s *loadStats) record(op string, latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[op]++
+	if err != nil {
+		if failureTypeOf(err) != "" {
+			s.failures[op] = append(s.failures[op], err)
+		} else {
+			s.errors[op] = append(s.errors[op], err)
+		}
+		return
+	}
+	s.latencies[op] = append(s.latencies[op], latency)
+}
+
+// apiErrorRate returns the ratio of api errors among all the calls, failed plans and jobs are not counted
+func (s *loadStats) apiErrorRate() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	calls, errs := 0, 0
+	for op, n := range s.calls {
+		calls += n
+		errs += len(s.errors[op])
+	}
+	if calls == 0 {
+		return 0
+	}
+	return float64(errs) / float64(calls)
+}
+
+// apiErrors returns the api errors of all the operations
+func (s *loadStats) apiErrors() []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for _, op := range loadOps {
+		errs = append(errs, s.errors[op]...)
+	}
+	return errs
+}
+
+// failureCount returns the number of plans and jobs which timed out or ended in a wrong phase
+func (s *loadStats) failureCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, failures := range s.failures {
+		n += len(failures)
+	}
+	return n
+}
+
+func (s *loadStats) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buf := &bytes.Buffer{}
+	w := tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OPERATION\tCALLS\tAPI ERRORS\tAPI ERROR RATE\tFAILED\tP50\tP90\tP99\tMAX")
+	for _, op := range loadOps {
+		calls := s.calls[op]
+		errs := len(s.errors[op])
+		rate := 0.0
+		if calls > 0 {
+			rate = float64(errs) / float64(calls)
+		}
+		latencies := append([]time.Duration{}, s.latencies[op]...)
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.2f%%\t%d\t%v\t%v\t%v\t%v\n", op, calls, errs, rate*100, len(s.failures[op]),
+			percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99), percentile(latencies, 100))
+	}
+	_ = w.Flush()
+	return buf.String()
+}
+
+// percentile returns the p-th percentile of the sorted latencies by the nearest rank, 0 if there is none
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1].Round(time.Millisecond)
+}
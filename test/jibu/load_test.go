@@ -0,0 +1,144 @@
+package jibu
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// loadJob is an on-demand job to start during the load test
+type loadJob struct {
+	planName string
+	jobName  string
+}
+
+// createLoadPlan creates a backup plan and waits for it to be ready, both steps are recorded in stats
+func createLoadPlan(env *testEnv, stats *loadStats, name string, namespace string) bool {
+	plan := newBackupPlan(env.tenant, name, env.cluster.Metadata.Name, env.storage.Metadata.Name, []string{namespace})
+	started := time.Now()
+	_, _, err := env.client.BackupPlanTagApi.CreateBackupPlan(ctx, env.tenant, plan)
+	stats.record(loadOpCreateBackupPlan, time.Since(started), err)
+	if err != nil {
+		return false
+	}
+	err = pollBackupPlanReady(env.client, env.tenant, name)
+	stats.record(loadOpBackupPlanReady, time.Since(started), err)
+	return err == nil
+}
+
+// runLoadJob starts an on-demand job and waits for it to complete, both steps are recorded in stats,
+// created is called once the creation call returns, before the wait
+func runLoadJob(env *testEnv, stats *loadStats, job loadJob, created func()) {
+	started := time.Now()
+	_, _, err := env.client.BackupJobTagApi.CreateBackupJob(ctx, env.tenant, newBackupJob(env.tenant, job.jobName, job.planName))
+	stats.record(loadOpCreateBackupJob, time.Since(started), err)
+	created()
+	if err != nil {
+		return
+	}
+	err = pollBackupJobComplete(env.client, env.tenant, job.jobName)
+	stats.record(loadOpBackupJobComplete, time.Since(started), err)
+}
+
+var _ = Describe("load", func() {
+	var env *testEnv
+
+	BeforeEach(func() {
+		if *argLoadPlans <= 0 {
+			Skip("load test is disabled, enable it with -jibu-load-plans")
+		}
+		env = getTestEnv()
+	})
+
+	It("should handle many simultaneous plans and jobs", func() {
+		kubeClient, dynamicClient := getK8sClientFromCluster(env.client, env.tenant, env.cluster.Metadata.Name)
+		stats := newLoadStats()
+
+		var namespaces []string
+		for i := 0; i < *argLoadNamespaces; i++ {
+			ns := &corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: randomName("jibutest-load")}}
+			_, err := kubeClient.CoreV1().Namespaces().Create(ctx, ns, v1.CreateOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+			namespaces = append(namespaces, ns.Name)
+			defer func() {
+				if *argCleanUpOnEnd {
+					_ = deleteNamespace(kubeClient, dynamicClient, ns.Name, true)
+				}
+			}()
+			if *argWorkloadManifestDir != "" {
+				Expect(seedWorkload(kubeClient, dynamicClient, *argWorkloadManifestDir, ns.Name)).ShouldNot(HaveOccurred())
+			}
+		}
+		MyBy(fmt.Sprintf("load: %d backup plans across namespaces %v, %d jobs per plan, concurrency %d", *argLoadPlans, namespaces, *argLoadJobsPerPlan, *argLoadConcurrency))
+
+		planNames := make([]string, *argLoadPlans)
+		for i := range planNames {
+			planNames[i] = randomName("load")
+		}
+		defer func() {
+			for _, name := range planNames {
+				deleteBackupPlanAndJobs(env, name)
+			}
+		}()
+
+		sem := make(chan struct{}, *argLoadConcurrency)
+		var wg sync.WaitGroup
+		ready := make([]bool, len(planNames))
+		for i, name := range planNames {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, name string) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				defer func() { <-sem }()
+				ready[i] = createLoadPlan(env, stats, name, namespaces[i%len(namespaces)])
+			}(i, name)
+		}
+		wg.Wait()
+
+		// jobs of different plans are interleaved, so that every plan gets busy as early as possible
+		var jobs []loadJob
+		for j := 0; j < *argLoadJobsPerPlan; j++ {
+			for i, name := range planNames {
+				if ready[i] {
+					jobs = append(jobs, loadJob{planName: name, jobName: fmt.Sprintf("%s-%d", name, j)})
+				}
+			}
+		}
+
+		// the creation of a job takes a slot of sem, the wait for it to complete a slot of running
+		running := make(chan struct{}, *argLoadMaxRunningJobs)
+		ticker := time.NewTicker(*argLoadJobInterval)
+		defer ticker.Stop()
+		for i, job := range jobs {
+			if i > 0 {
+				<-ticker.C
+			}
+			wg.Add(1)
+			running <- struct{}{}
+			sem <- struct{}{}
+			go func(job loadJob) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				defer func() { <-running }()
+				var once sync.Once
+				release := func() { once.Do(func() { <-sem }) }
+				defer release()
+				runLoadJob(env, stats, job, release)
+			}(job)
+		}
+		wg.Wait()
+
+		MyBy(fmt.Sprintf("load test report:\n%s", stats.String()))
+		if n := stats.failureCount(); n > 0 {
+			log.warn("some backup plans or jobs of the load test failed, they don't count in the api error rate", "failed", n)
+		}
+		Expect(stats.apiErrorRate()).Should(BeNumerically("<=", *argLoadMaxErrorRate), "api errors: %v", stats.apiErrors())
+	})
+})
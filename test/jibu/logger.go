@@ -1,14 +1,264 @@
 package jibu
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	. "github.com/onsi/ginkgo"
+
+	"github.com/stoneshi-yunify/jibutest/pkg/utils/random"
+)
+
+// logLevel is the severity of a log line
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
 )
 
-// MyBy adds a timestamp before text
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (l logLevel) String() string {
+	return logLevelNames[l]
+}
+
+// minLogLevel is parsed from -jibu-log-level by validateFlags
+var minLogLevel = levelInfo
+
+func parseLogLevel(s string) (logLevel, error) {
+	for i, name := range logLevelNames {
+		if strings.EqualFold(s, name) {
+			return logLevel(i), nil
+		}
+	}
+	return levelInfo, fmt.Errorf("invalid log level %s, expected one of %v", s, logLevelNames)
+}
+
+// the keys of the fields the log pipeline filters on
+const (
+	logKeyRunID     = "run"
+	logKeyScenario  = "scenario"
+	logKeyCluster   = "cluster"
+	logKeyNamespace = "namespace"
+	logKeyPlan      = "plan"
+	logKeyJob       = "job"
+	logKeyKind      = "kind"
+)
+
+var generatedRunID = strings.ToLower(random.GetRandString(8))
+
+// runID tells the lines of one test run apart, it's random unless set by -jibu-run-id
+func runID() string {
+	if *argRunID != "" {
+		return *argRunID
+	}
+	return generatedRunID
+}
+
+// logField is a key/value pair attached to a log line
+type logField struct {
+	key   string
+	value interface{}
+}
+
+// specGoroutine is the goroutine running the specs, ginkgo runs the synchronous nodes of a spec in one goroutine
+var specGoroutine struct {
+	sync.Mutex
+	id uint64
+}
+
+var _ = BeforeEach(func() {
+	specGoroutine.Lock()
+	defer specGoroutine.Unlock()
+	specGoroutine.id = goroutineID()
+})
+
+// goroutineID parses the id of the calling goroutine from the first line of its stack, "goroutine 18 [running]:"
+func goroutineID() uint64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	fields := bytes.Fields(buf)
+	if len(fields) < 2 {
+		return 0
+	}
+	id, _ := strconv.ParseUint(string(fields[1]), 10, 64)
+	return id
+}
+
+// currentScenario returns the running spec if called from the spec goroutine. Other goroutines can't tell which
+// spec they belong to, so they get the scenario here before they start and add it with log.with(logKeyScenario, ...).
+func currentScenario() string {
+	specGoroutine.Lock()
+	owner := specGoroutine.id
+	specGoroutine.Unlock()
+	if owner == 0 || owner != goroutineID() {
+		return ""
+	}
+	return CurrentGinkgoTestDescription().FullTestText
+}
+
+func hasLogField(fields []logField, key string) bool {
+	for _, f := range fields {
+		if f.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// logger writes leveled lines with fields, as text through ginkgo and as json lines into -jibu-log-file
+type logger struct {
+	fields []logField
+}
+
+// log is the root logger, the loggers derived from it with with() add their fields to every line
+var log = &logger{}
+
+var logFileLock sync.Mutex
+
+// with returns a logger adding the key/value pairs to every line, e.g. log.with(logKeyPlan, name)
+func (l *logger) with(keysAndValues ...interface{}) *logger {
+	fields := append(append([]logField{}, l.fields...), toLogFields(keysAndValues)...)
+	return &logger{fields: fields}
+}
+
+func (l *logger) debug(msg string, keysAndValues ...interface{}) {
+	l.output(levelDebug, msg, keysAndValues)
+}
+
+func (l *logger) info(msg string, keysAndValues ...interface{}) {
+	l.output(levelInfo, msg, keysAndValues)
+}
+
+func (l *logger) warn(msg string, keysAndValues ...interface{}) {
+	l.output(levelWarn, msg, keysAndValues)
+}
+
+func (l *logger) error(msg string, keysAndValues ...interface{}) {
+	l.output(levelError, msg, keysAndValues)
+}
+
+func toLogFields(keysAndValues []interface{}) []logField {
+	fields := make([]logField, 0, len(keysAndValues)/2)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key := fmt.Sprint(keysAndValues[i])
+		var value interface{} = "(missing)"
+		if i+1 < len(keysAndValues) {
+			value = keysAndValues[i+1]
+		}
+		fields = append(fields, logField{key: key, value: value})
+	}
+	return fields
+}
+
+// output writes the line if its level is enabled. The run id is always added, the running spec is added
+// on the spec goroutine unless the line already has a scenario.
+func (l *logger) output(level logLevel, msg string, keysAndValues []interface{}) {
+	if level < minLogLevel {
+		return
+	}
+
+	now := time.Now()
+	fields := []logField{{key: logKeyRunID, value: runID()}}
+	extra := append(append([]logField{}, l.fields...), toLogFields(keysAndValues)...)
+	if !hasLogField(extra, logKeyScenario) {
+		if scenario := currentScenario(); scenario != "" {
+			fields = append(fields, logField{key: logKeyScenario, value: scenario})
+		}
+	}
+	fields = append(fields, extra...)
+
+	// every line is redacted, as reports and diagnostics may carry credentials in api errors or dumped objects
+	msg = redactText(msg)
+	for i := range fields {
+		fields[i].value = redactField(fields[i])
+	}
+
+	text := fmt.Sprintf("%v %s %s", now.Format("2006-01-02 15:04:05"), strings.ToUpper(level.String()), msg)
+	for _, f := range fields[1:] {
+		if f.key != logKeyScenario {
+			text += fmt.Sprintf(" %s=%v", f.key, f.value)
+		}
+	}
+	By(text)
+
+	if *argLogFile != "" {
+		writeJSONLine(now, level, msg, fields)
+	}
+}
+
+func writeJSONLine(t time.Time, level logLevel, msg string, fields []logField) {
+	line := map[string]interface{}{
+		"time":  t.Format(time.RFC3339Nano),
+		"level": level.String(),
+		"msg":   msg,
+	}
+	for _, f := range fields {
+		line[f.key] = f.value
+	}
+	raw, err := json.Marshal(line)
+	if err != nil {
+		return
+	}
+
+	logFileLock.Lock()
+	defer logFileLock.Unlock()
+	file, err := os.OpenFile(*argLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	_, _ = file.Write(append(raw, '\n'))
+}
+
+// redactField returns the value of the field to write, errors are turned into their redacted message
+func redactField(f logField) interface{} {
+	if isSensitiveField(f.key) {
+		return redactedMask
+	}
+	switch value := f.value.(type) {
+	case error:
+		return redactText(value.Error())
+	case string:
+		return redactText(value)
+	case fmt.Stringer:
+		return redactText(value.String())
+	default:
+		return value
+	}
+}
+
+// resourceLogger returns a logger with the plan or job field set according to the kind
+func resourceLogger(kind string, name string) *logger {
+	key := logKeyJob
+	if kind == kindBackupPlan || kind == kindRestorePlan {
+		key = logKeyPlan
+	}
+	return log.with(logKeyKind, kind, key, name)
+}
+
+// scenarioLogger is resourceLogger with the scenario added explicitly, for the lines of background goroutines
+func scenarioLogger(scenario string, kind string, name string) *logger {
+	if scenario == "" {
+		return resourceLogger(kind, name)
+	}
+	return resourceLogger(kind, name).with(logKeyScenario, scenario)
+}
+
+// MyBy logs text at info level, then runs the callbacks as ginkgo By does
 func MyBy(text string, callbacks ...func()) {
-	text = fmt.Sprintf("%v %s", time.Now().Format("2006-01-02 15:04:05"), text)
-	By(text, callbacks...)
+	log.info(text)
+	for _, callback := range callbacks {
+		callback()
+	}
 }
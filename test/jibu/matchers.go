@@ -0,0 +1,270 @@
+package jibu
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/elliotchance/pie/pie"
+	swagger "github.com/jibutech/backup-saas-client"
+	"github.com/onsi/gomega/types"
+)
+
+const (
+	kindBackupPlan  = "backup plan"
+	kindBackupJob   = "backup job"
+	kindRestorePlan = "restore plan"
+	kindRestoreJob  = "restore job"
+)
+
+// phaseHistories remembers every phase observed for a resource, keyed by {kind}/{name},
+// it's filled through observePhase by the poll helpers and the getters, and printed by the matchers when they fail
+var phaseHistories = struct {
+	sync.Mutex
+	phases map[string][]string
+}{phases: make(map[string][]string)}
+
+// recordPhase appends the phase to the history of the resource if it differs from the last observed one
+func recordPhase(kind string, name string, phase string) {
+	phaseHistories.Lock()
+	defer phaseHistories.Unlock()
+	key := kind + "/" + name
+	history := phaseHistories.phases[key]
+	if len(history) == 0 || history[len(history)-1] != phase {
+		phaseHistories.phases[key] = append(history, phase)
+	}
+}
+
+func phaseHistory(kind string, name string) string {
+	phaseHistories.Lock()
+	defer phaseHistories.Unlock()
+	history := phaseHistories.phases[kind+"/"+name]
+	if len(history) == 0 {
+		return "none observed"
+	}
+	return strings.Join(history, " -> ")
+}
+
+// resourceState is what the matchers need to know about a jibu resource
+type resourceState struct {
+	kind    string
+	name    string
+	phase   string
+	message string
+}
+
+func (s resourceState) String() string {
+	return fmt.Sprintf("%s %s\n  phase: %s\n  message: %s\n  phase history: %s", s.kind, s.name, s.phase, s.message, phaseHistory(s.kind, s.name))
+}
+
+// stateOf extracts the state of a plan or a job, given by value or by pointer, and records its phase
+func stateOf(actual interface{}) (resourceState, error) {
+	var s resourceState
+	switch r := actual.(type) {
+	case swagger.V1alpha1BackupPlan:
+		s = resourceState{kind: kindBackupPlan, name: r.Metadata.Name, phase: r.Status.Phase, message: r.Status.Message}
+	case *swagger.V1alpha1BackupPlan:
+		return stateOf(*r)
+	case swagger.V1alpha1BackupJob:
+		s = resourceState{kind: kindBackupJob, name: r.Metadata.Name, phase: r.Status.Phase, message: r.Status.Message}
+	case *swagger.V1alpha1BackupJob:
+		return stateOf(*r)
+	case swagger.V1alpha1RestorePlan:
+		s = resourceState{kind: kindRestorePlan, name: r.Metadata.Name, phase: r.Status.Phase, message: r.Status.Message}
+	case *swagger.V1alpha1RestorePlan:
+		return stateOf(*r)
+	case swagger.V1alpha1RestoreJob:
+		s = resourceState{kind: kindRestoreJob, name: r.Metadata.Name, phase: r.Status.Phase, message: r.Status.Message}
+	case *swagger.V1alpha1RestoreJob:
+		return stateOf(*r)
+	default:
+		return s, fmt.Errorf("expected a jibu plan or job, got %T", actual)
+	}
+	recordPhase(s.kind, s.name, s.phase)
+	return s, nil
+}
+
+// phaseMatcher matches plans or jobs in the given phase
+type phaseMatcher struct {
+	kinds []string
+	phase PhaseType
+	state resourceState
+}
+
+// HaveJobPhase succeeds if the backup or restore job is in the phase
+func HaveJobPhase(phase PhaseType) types.GomegaMatcher {
+	return &phaseMatcher{kinds: []string{kindBackupJob, kindRestoreJob}, phase: phase}
+}
+
+// BeReadyPlan succeeds if the backup or restore plan is ready
+func BeReadyPlan() types.GomegaMatcher {
+	return &phaseMatcher{kinds: []string{kindBackupPlan, kindRestorePlan}, phase: PhaseReady}
+}
+
+func (m *phaseMatcher) Match(actual interface{}) (bool, error) {
+	state, err := stateOf(actual)
+	if err != nil {
+		return false, err
+	}
+	if !pie.Strings(m.kinds).Contains(state.kind) {
+		return false, fmt.Errorf("expected a %s, got a %s", strings.Join(m.kinds, " or a "), state.kind)
+	}
+	m.state = state
+	return state.phase == string(m.phase), nil
+}
+
+func (m *phaseMatcher) FailureMessage(actual interface{}) string {
+	return fmt.Sprintf("Expected %s\nto be in phase %s", m.state, m.phase)
+}
+
+func (m *phaseMatcher) NegatedFailureMessage(actual interface{}) string {
+	return fmt.Sprintf("Expected %s\nnot to be in phase %s", m.state, m.phase)
+}
+
+// namespaceMappingMatcher matches restore plans mapping a backup namespace to a restore namespace
+type namespaceMappingMatcher struct {
+	mapping  string
+	plan     string
+	mappings []string
+}
+
+// HaveNamespaceMapping succeeds if the restore plan restores the backup namespace into the restore namespace
+func HaveNamespaceMapping(backupNamespace string, restoreNamespace string) types.GomegaMatcher {
+	return &namespaceMappingMatcher{mapping: fmt.Sprintf("%s:%s", backupNamespace, restoreNamespace)}
+}
+
+func (m *namespaceMappingMatcher) Match(actual interface{}) (bool, error) {
+	var plan swagger.V1alpha1RestorePlan
+	switch p := actual.(type) {
+	case swagger.V1alpha1RestorePlan:
+		plan = p
+	case *swagger.V1alpha1RestorePlan:
+		plan = *p
+	default:
+		return false, fmt.Errorf("expected a restore plan, got %T", actual)
+	}
+	m.plan = plan.Metadata.Name
+	m.mappings = plan.Spec.NamespaceMappings
+	return pie.Strings(m.mappings).Contains(m.mapping), nil
+}
+
+func (m *namespaceMappingMatcher) FailureMessage(actual interface{}) string {
+	return fmt.Sprintf("Expected restore plan %s\nto have namespace mapping %s\nbut it has %v", m.plan, m.mapping, m.mappings)
+}
+
+func (m *namespaceMappingMatcher) NegatedFailureMessage(actual interface{}) string {
+	return fmt.Sprintf("Expected restore plan %s\nnot to have namespace mapping %s", m.plan, m.mapping)
+}
+
+// resourcesMatcher matches a list of kubernetes objects containing all the expected ones
+type resourcesMatcher struct {
+	expected []string
+	missing  []string
+	present  []string
+}
+
+// ContainResources succeeds if the objects include every expected one, given as {kind}/{name}, e.g. Deployment/nginx
+func ContainResources(expected ...string) types.GomegaMatcher {
+	return &resourcesMatcher{expected: expected}
+}
+
+func (m *resourcesMatcher) Match(actual interface{}) (bool, error) {
+	var objs []unstructured.Unstructured
+	switch o := actual.(type) {
+	case []unstructured.Unstructured:
+		objs = o
+	case *unstructured.UnstructuredList:
+		objs = o.Items
+	case []*unstructured.Unstructured:
+		for _, obj := range o {
+			objs = append(objs, *obj)
+		}
+	default:
+		return false, fmt.Errorf("expected a list of unstructured objects, got %T", actual)
+	}
+
+	m.present = make([]string, 0, len(objs))
+	for _, obj := range objs {
+		m.present = append(m.present, obj.GetKind()+"/"+obj.GetName())
+	}
+	sort.Strings(m.present)
+	m.missing = nil
+	for _, e := range m.expected {
+		if !pie.Strings(m.present).Contains(e) {
+			m.missing = append(m.missing, e)
+		}
+	}
+	return len(m.missing) == 0, nil
+}
+
+func (m *resourcesMatcher) FailureMessage(actual interface{}) string {
+	return fmt.Sprintf("Expected resources %v\nbut %v are missing\npresent resources: %v", m.expected, m.missing, m.present)
+}
+
+func (m *resourcesMatcher) NegatedFailureMessage(actual interface{}) string {
+	return fmt.Sprintf("Expected resources %v\nnot to be all present\npresent resources: %v", m.expected, m.present)
+}
+
+// The getters below can be polled by Eventually, e.g.
+// Eventually(backupJobGetter(client, tenant, name), timeout, interval).Should(HaveJobPhase(JobPhaseCompleted))
+
+func backupPlanGetter(jibuClient *swagger.APIClient, tenant string, name string) func() (swagger.V1alpha1BackupPlan, error) {
+	return func() (swagger.V1alpha1BackupPlan, error) {
+		p, _, err := jibuClient.BackupPlanTagApi.GetBackupPlan(ctx, tenant, name)
+		if err == nil {
+			observePhase(kindBackupPlan, name, p.Status.Phase, p.Status.Message, p.Status)
+		}
+		return p, err
+	}
+}
+
+func backupJobGetter(jibuClient *swagger.APIClient, tenant string, name string) func() (swagger.V1alpha1BackupJob, error) {
+	return func() (swagger.V1alpha1BackupJob, error) {
+		j, _, err := jibuClient.BackupJobTagApi.GetBackupJob(ctx, tenant, name)
+		if err == nil {
+			observePhase(kindBackupJob, name, j.Status.Phase, j.Status.Message, j.Status)
+		}
+		return j, err
+	}
+}
+
+func restorePlanGetter(jibuClient *swagger.APIClient, tenant string, name string) func() (swagger.V1alpha1RestorePlan, error) {
+	return func() (swagger.V1alpha1RestorePlan, error) {
+		p, _, err := jibuClient.RestorePlanTagApi.GetRestorePlan(ctx, tenant, name)
+		if err == nil {
+			observePhase(kindRestorePlan, name, p.Status.Phase, p.Status.Message, p.Status)
+		}
+		return p, err
+	}
+}
+
+func restoreJobGetter(jibuClient *swagger.APIClient, tenant string, name string) func() (swagger.V1alpha1RestoreJob, error) {
+	return func() (swagger.V1alpha1RestoreJob, error) {
+		j, _, err := jibuClient.RestoreJobTagApi.GetRestoreJob(ctx, tenant, name)
+		if err == nil {
+			observePhase(kindRestoreJob, name, j.Status.Phase, j.Status.Message, j.Status)
+		}
+		return j, err
+	}
+}
+
+// namespaceResourcesGetter lists the objects of the given resources in the namespace, to be matched by ContainResources
+func namespaceResourcesGetter(dynamicClient dynamic.Interface, namespace string, gvrs ...schema.GroupVersionResource) func() ([]unstructured.Unstructured, error) {
+	return func() ([]unstructured.Unstructured, error) {
+		var objs []unstructured.Unstructured
+		for _, gvr := range gvrs {
+			list, err := dynamicClient.Resource(gvr).Namespace(namespace).List(ctx, v1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+			objs = append(objs, list.Items...)
+		}
+		return objs, nil
+	}
+}
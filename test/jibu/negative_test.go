@@ -0,0 +1,276 @@
+package jibu
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	swagger "github.com/jibutech/backup-saas-client"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// contractFixture holds the valid plans the invalid requests are derived from,
+// they are created once and deleted after the suite
+type contractFixture struct {
+	backupPlanName  string
+	restorePlanName string
+}
+
+var contract *contractFixture
+
+func getContractFixture(env *testEnv) *contractFixture {
+	if contract != nil {
+		return contract
+	}
+
+	f := &contractFixture{
+		backupPlanName:  randomName("contract-backup"),
+		restorePlanName: randomName("contract-restore"),
+	}
+	MyBy(fmt.Sprintf("create a valid backup plan %s for the contract tests", f.backupPlanName))
+	backupPlan := newBackupPlan(env.tenant, f.backupPlanName, env.cluster.Metadata.Name, env.storage.Metadata.Name, []string{env.namespace})
+	_, _, err := env.client.BackupPlanTagApi.CreateBackupPlan(ctx, env.tenant, backupPlan)
+	Expect(err).ShouldNot(HaveOccurred())
+	deferCleanup(func() {
+		_, _, _ = env.client.BackupPlanTagApi.DeleteBackupPlan(ctx, env.tenant, f.backupPlanName)
+		_ = deleteJobsOfBackupPlan(env.client, env.tenant, f.backupPlanName)
+	})
+	waitBackupPlanReady(env.client, env.tenant, f.backupPlanName)
+
+	MyBy(fmt.Sprintf("create a valid restore plan %s for the contract tests", f.restorePlanName))
+	mapping := fmt.Sprintf("%s:%s", env.namespace, determineDestNamespaceName(false, env.namespace))
+	restorePlan := newRestorePlan(env.tenant, f.restorePlanName, f.backupPlanName, env.cluster.Metadata.Name, []string{mapping})
+	_, _, err = env.client.RestorePlanTagApi.CreateRestorePlan(ctx, env.tenant, restorePlan)
+	Expect(err).ShouldNot(HaveOccurred())
+	deferCleanup(func() {
+		_, _, _ = env.client.RestorePlanTagApi.DeleteRestorePlan(ctx, env.tenant, f.restorePlanName)
+	})
+
+	contract = f
+	return contract
+}
+
+// expectAPIError asserts the call is rejected with the status code and the error body mentions rejected,
+// which is the name of the rejected field or of the resource which doesn't exist
+func expectAPIError(resp *http.Response, err error, statusCode int, rejected string) {
+	Expect(err).Should(HaveOccurred(), "invalid request is accepted")
+	Expect(apiStatusCode(resp)).Should(Equal(statusCode), "unexpected status code, body: %s", apiErrorBody(err))
+	Expect(strings.ToLower(apiErrorBody(err))).Should(ContainSubstring(strings.ToLower(rejected)), "error body doesn't mention %s", rejected)
+}
+
+// expectNotFound asserts nothing is left behind by a rejected creation
+func expectNotFound(resp *http.Response, err error, kind string, name string) {
+	Expect(err).Should(HaveOccurred(), "%s %s is left behind by a rejected creation", kind, name)
+	Expect(apiStatusCode(resp)).Should(Equal(http.StatusNotFound), "unexpected status code getting %s %s, body: %s", kind, name, apiErrorBody(err))
+}
+
+// findUnreachableStorage returns -jibu-unreachable-storage, or any storage of the tenant which isn't ready, empty if there is none
+func findUnreachableStorage(env *testEnv) string {
+	if *argUnreachableStorage != "" {
+		return *argUnreachableStorage
+	}
+	storageList, _, err := env.client.StorageApi.ListStorages(ctx, env.tenant, nil)
+	Expect(err).ShouldNot(HaveOccurred())
+	for _, s := range storageList.Items {
+		if s.Status.Phase != string(PhaseReady) {
+			return s.Metadata.Name
+		}
+	}
+	return ""
+}
+
+// createFailedBackupJob backs up to an unreachable storage and waits for the job to fail, returns the plan and the job,
+// labels are set on both of them
+func createFailedBackupJob(env *testEnv, labels map[string]string) (swagger.V1alpha1BackupPlan, string) {
+	storage := findUnreachableStorage(env)
+	if storage == "" {
+		Skip(fmt.Sprintf("no unreachable storage in tenant %s to create a failed backup job, set one with -jibu-unreachable-storage", env.tenant))
+	}
+
+	planName := randomName("contract-failing")
+	plan := newBackupPlan(env.tenant, planName, env.cluster.Metadata.Name, storage, []string{env.namespace})
+	plan.Metadata.Labels = labels
+	_, _, err := env.client.BackupPlanTagApi.CreateBackupPlan(ctx, env.tenant, plan)
+	Expect(err).ShouldNot(HaveOccurred())
+	deferCleanup(func() {
+		_, _, _ = env.client.BackupPlanTagApi.DeleteBackupPlan(ctx, env.tenant, planName)
+		_ = deleteJobsOfBackupPlan(env.client, env.tenant, planName)
+	})
+
+	jobName := randomName("contract-failing-job")
+	MyBy(fmt.Sprintf("back up to unreachable storage %s with job %s, which should fail", storage, jobName))
+	job := newBackupJob(env.tenant, jobName, planName)
+	job.Metadata.Labels = labels
+	_, _, err = env.client.BackupJobTagApi.CreateBackupJob(ctx, env.tenant, job)
+	Expect(err).ShouldNot(HaveOccurred())
+	err = pollBackupJobComplete(env.client, env.tenant, jobName)
+	Expect(failureTypeOf(err)).Should(Equal(failureJobFailed), "backup job %s to unreachable storage %s didn't fail: %v", jobName, storage, err)
+	return plan, jobName
+}
+
+var _ = Describe("jibu api contract", func() {
+	var env *testEnv
+	var fixture *contractFixture
+
+	BeforeEach(func() {
+		if !*argNegativeTestEnabled {
+			Skip("negative api contract tests are disabled, enable them with -jibu-negative-test-enabled")
+		}
+		env = getTestEnv()
+		fixture = getContractFixture(env)
+	})
+
+	DescribeTable("creating an invalid backup plan should be rejected",
+		func(mutate func(p *swagger.V1alpha1BackupPlan) string, statusCode int) {
+			name := randomName("contract-backup")
+			plan := newBackupPlan(env.tenant, name, env.cluster.Metadata.Name, env.storage.Metadata.Name, []string{env.namespace})
+			rejected := mutate(&plan)
+			_, resp, err := env.client.BackupPlanTagApi.CreateBackupPlan(ctx, env.tenant, plan)
+			expectAPIError(resp, err, statusCode, rejected)
+
+			if plan.Metadata.Name != name {
+				MyBy(fmt.Sprintf("the existing backup plan %s should be untouched", plan.Metadata.Name))
+				existing, _, err := env.client.BackupPlanTagApi.GetBackupPlan(ctx, env.tenant, plan.Metadata.Name)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(existing.Spec.Desc).ShouldNot(Equal(plan.Spec.Desc))
+				return
+			}
+			_, resp, err = env.client.BackupPlanTagApi.GetBackupPlan(ctx, env.tenant, name)
+			expectNotFound(resp, err, "backup plan", name)
+			_, err = pickOneJobOfBackupPlan(env.client, env.tenant, name, 0)
+			Expect(err).Should(HaveOccurred(), "backup job is created for the rejected backup plan %s", name)
+		},
+		Entry("malformed cron frequency", func(p *swagger.V1alpha1BackupPlan) string {
+			p.Spec.Policy.Repeat = true
+			p.Spec.Policy.Frequency = "every 3 minutes"
+			return "frequency"
+		}, http.StatusBadRequest),
+		Entry("cron frequency out of range", func(p *swagger.V1alpha1BackupPlan) string {
+			p.Spec.Policy.Repeat = true
+			p.Spec.Policy.Frequency = "61 * * * *"
+			return "frequency"
+		}, http.StatusBadRequest),
+		Entry("unknown storage", func(p *swagger.V1alpha1BackupPlan) string {
+			p.Spec.StorageName = randomName("contract-storage")
+			return p.Spec.StorageName
+		}, http.StatusBadRequest),
+		Entry("unknown cluster", func(p *swagger.V1alpha1BackupPlan) string {
+			p.Spec.ClusterName = randomName("contract-cluster")
+			return p.Spec.ClusterName
+		}, http.StatusBadRequest),
+		Entry("invalid copy method", func(p *swagger.V1alpha1BackupPlan) string {
+			p.Spec.CopyMethod = "teleport"
+			return "copyMethod"
+		}, http.StatusBadRequest),
+		Entry("duplicate plan name", func(p *swagger.V1alpha1BackupPlan) string {
+			p.Metadata.Name = fixture.backupPlanName
+			return p.Metadata.Name
+		}, http.StatusConflict),
+	)
+
+	DescribeTable("creating an invalid backup job should be rejected",
+		func(mutate func(j *swagger.V1alpha1BackupJob) string, statusCode int) {
+			name := randomName("contract-backup-job")
+			job := newBackupJob(env.tenant, name, fixture.backupPlanName)
+			rejected := mutate(&job)
+			_, resp, err := env.client.BackupJobTagApi.CreateBackupJob(ctx, env.tenant, job)
+			expectAPIError(resp, err, statusCode, rejected)
+
+			_, resp, err = env.client.BackupJobTagApi.GetBackupJob(ctx, env.tenant, name)
+			expectNotFound(resp, err, "backup job", name)
+		},
+		Entry("unknown backup plan", func(j *swagger.V1alpha1BackupJob) string {
+			j.Spec.BackupName = randomName("contract-backup")
+			return j.Spec.BackupName
+		}, http.StatusBadRequest),
+		Entry("unknown action", func(j *swagger.V1alpha1BackupJob) string {
+			j.Spec.Action = "ExplodeJob"
+			return "action"
+		}, http.StatusBadRequest),
+	)
+
+	DescribeTable("creating an invalid restore plan should be rejected",
+		func(mutate func(p *swagger.V1alpha1RestorePlan) string, statusCode int) {
+			name := randomName("contract-restore")
+			mapping := fmt.Sprintf("%s:%s", env.namespace, determineDestNamespaceName(false, env.namespace))
+			plan := newRestorePlan(env.tenant, name, fixture.backupPlanName, env.cluster.Metadata.Name, []string{mapping})
+			rejected := mutate(&plan)
+			_, resp, err := env.client.RestorePlanTagApi.CreateRestorePlan(ctx, env.tenant, plan)
+			expectAPIError(resp, err, statusCode, rejected)
+
+			_, resp, err = env.client.RestorePlanTagApi.GetRestorePlan(ctx, env.tenant, name)
+			expectNotFound(resp, err, "restore plan", name)
+		},
+		Entry("namespace mapping without separator", func(p *swagger.V1alpha1RestorePlan) string {
+			p.Spec.NamespaceMappings = []string{env.namespace}
+			return "namespaceMappings"
+		}, http.StatusBadRequest),
+		Entry("namespace mapping with too many parts", func(p *swagger.V1alpha1RestorePlan) string {
+			p.Spec.NamespaceMappings = []string{fmt.Sprintf("%s:a:b", env.namespace)}
+			return "namespaceMappings"
+		}, http.StatusBadRequest),
+		Entry("namespace mapping without source", func(p *swagger.V1alpha1RestorePlan) string {
+			p.Spec.NamespaceMappings = []string{":target"}
+			return "namespaceMappings"
+		}, http.StatusBadRequest),
+		Entry("namespace mapping without target", func(p *swagger.V1alpha1RestorePlan) string {
+			p.Spec.NamespaceMappings = []string{env.namespace + ":"}
+			return "namespaceMappings"
+		}, http.StatusBadRequest),
+		Entry("namespace mapping to an invalid name", func(p *swagger.V1alpha1RestorePlan) string {
+			p.Spec.NamespaceMappings = []string{env.namespace + ":Not_A_Namespace"}
+			return "Not_A_Namespace"
+		}, http.StatusBadRequest),
+		Entry("unknown backup plan", func(p *swagger.V1alpha1RestorePlan) string {
+			p.Spec.BackupName = randomName("contract-backup")
+			return p.Spec.BackupName
+		}, http.StatusBadRequest),
+		Entry("unknown destination cluster", func(p *swagger.V1alpha1RestorePlan) string {
+			p.Spec.DestClusterName = randomName("contract-cluster")
+			return p.Spec.DestClusterName
+		}, http.StatusBadRequest),
+	)
+
+	DescribeTable("creating an invalid restore job should be rejected",
+		func(mutate func(j *swagger.V1alpha1RestoreJob) string, statusCode int) {
+			name := randomName("contract-restore-job")
+			job := newRestoreJob(env.tenant, name, fixture.restorePlanName, "")
+			rejected := mutate(&job)
+			_, resp, err := env.client.RestoreJobTagApi.CreateRestoreJob(ctx, env.tenant, job)
+			expectAPIError(resp, err, statusCode, rejected)
+
+			_, resp, err = env.client.RestoreJobTagApi.GetRestoreJob(ctx, env.tenant, name)
+			expectNotFound(resp, err, "restore job", name)
+		},
+		Entry("unknown backup job", func(j *swagger.V1alpha1RestoreJob) string {
+			j.Spec.BackupJobName = randomName("contract-backup-job")
+			return j.Spec.BackupJobName
+		}, http.StatusBadRequest),
+		Entry("unknown restore plan", func(j *swagger.V1alpha1RestoreJob) string {
+			j.Spec.RestoreName = randomName("contract-restore")
+			j.Spec.BackupJobName = randomName("contract-backup-job")
+			return j.Spec.RestoreName
+		}, http.StatusBadRequest),
+		Entry("failed backup job", func(j *swagger.V1alpha1RestoreJob) string {
+			backupPlan, failedJobName := createFailedBackupJob(env, nil)
+			// restore the namespaces of the failed job's own plan to its cluster, so that only the job phase is wrong
+			var mappings []string
+			for _, ns := range backupPlan.Spec.Namespaces {
+				mappings = append(mappings, fmt.Sprintf("%s:%s", ns, determineDestNamespaceName(false, ns)))
+			}
+			restorePlanName := randomName("contract-restore")
+			plan := newRestorePlan(env.tenant, restorePlanName, backupPlan.Metadata.Name, backupPlan.Spec.ClusterName, mappings)
+			_, _, err := env.client.RestorePlanTagApi.CreateRestorePlan(ctx, env.tenant, plan)
+			Expect(err).ShouldNot(HaveOccurred())
+			deferCleanup(func() {
+				_, _, _ = env.client.RestorePlanTagApi.DeleteRestorePlan(ctx, env.tenant, restorePlanName)
+			})
+			j.Spec.RestoreName = restorePlanName
+			j.Spec.BackupJobName = failedJobName
+			return failedJobName
+		}, http.StatusBadRequest),
+	)
+})
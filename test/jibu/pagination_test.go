@@ -0,0 +1,131 @@
+package jibu
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	paginationTestPageSize = 5
+	// more than two pages with a partial last one
+	paginationTestJobs = 2*paginationTestPageSize + 2
+	// jobs created while the pages are walked
+	paginationTestConcurrentJobs = paginationTestPageSize
+)
+
+// paginationJobName returns the name of the i-th job of the plan, names sort in the order of creation
+func paginationJobName(planName string, i int) string {
+	return fmt.Sprintf("%s-%03d", planName, i)
+}
+
+func createPaginationJobs(env *testEnv, planName string, from int, to int) {
+	for i := from; i < to; i++ {
+		job := newBackupJob(env.tenant, paginationJobName(planName, i), planName)
+		_, _, err := env.client.BackupJobTagApi.CreateBackupJob(ctx, env.tenant, job)
+		Expect(err).ShouldNot(HaveOccurred())
+	}
+}
+
+// walkBackupJobPages lists the pages of the query until a short page, returns the names of all the listed jobs in order.
+// Duplicates are kept for expectNoDuplicates, so it fails after listMaxPages pages instead of stopping on a page without new jobs.
+func walkBackupJobPages(env *testEnv, q listQuery, pageSize int) []string {
+	var names []string
+	for page := 1; page <= listMaxPages; page++ {
+		jobList, _, err := env.client.BackupJobTagApi.ListBackupJobs(ctx, env.tenant, q.page(page, pageSize).backupJobOpts())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(len(jobList.Items)).Should(BeNumerically("<=", pageSize), "page %d holds more than %d items", page, pageSize)
+		for _, j := range jobList.Items {
+			names = append(names, j.Metadata.Name)
+		}
+		if len(jobList.Items) < pageSize {
+			return names
+		}
+	}
+	Fail(fmt.Sprintf("more than %d pages of %d backup jobs, the server may ignore page and limit", listMaxPages, pageSize))
+	return nil
+}
+
+// expectNoDuplicates asserts each name is listed only once
+func expectNoDuplicates(names []string) {
+	seen := make(map[string]int)
+	for i, name := range names {
+		if prev, ok := seen[name]; ok {
+			Fail(fmt.Sprintf("%s is listed at both %d and %d", name, prev, i))
+		}
+		seen[name] = i
+	}
+}
+
+var _ = Describe("list pagination", func() {
+	var env *testEnv
+	var planName string
+
+	BeforeEach(func() {
+		if !*argPaginationTestEnabled {
+			Skip("list pagination tests are disabled, enable them with -jibu-pagination-test-enabled")
+		}
+		env = getTestEnv()
+
+		planName = randomName("paging")
+		MyBy(fmt.Sprintf("create backup plan %s with %d jobs, %d per page", planName, paginationTestJobs, paginationTestPageSize))
+		plan := newBackupPlan(env.tenant, planName, env.cluster.Metadata.Name, env.storage.Metadata.Name, []string{env.namespace})
+		_, _, err := env.client.BackupPlanTagApi.CreateBackupPlan(ctx, env.tenant, plan)
+		Expect(err).ShouldNot(HaveOccurred())
+		waitBackupPlanReady(env.client, env.tenant, planName)
+		createPaginationJobs(env, planName, 0, paginationTestJobs)
+	})
+
+	AfterEach(func() {
+		if planName != "" {
+			deleteBackupPlanAndJobs(env, planName)
+		}
+	})
+
+	It("should list every job exactly once across pages", func() {
+		for _, ascending := range []bool{true, false} {
+			q := newListQuery().byPlan(planName).sortBy(listFieldName, ascending)
+			names := walkBackupJobPages(env, q, paginationTestPageSize)
+			expectNoDuplicates(names)
+			Expect(names).Should(HaveLen(paginationTestJobs), "ascending %v", ascending)
+			for i, name := range names {
+				expected := i
+				if !ascending {
+					expected = paginationTestJobs - 1 - i
+				}
+				Expect(name).Should(Equal(paginationJobName(planName, expected)), "position %d, ascending %v", i, ascending)
+			}
+		}
+	})
+
+	It("should keep the order stable while new jobs are created", func() {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			defer wg.Done()
+			for i := paginationTestJobs; i < paginationTestJobs+paginationTestConcurrentJobs; i++ {
+				createPaginationJobs(env, planName, i, i+1)
+				time.Sleep(500 * time.Millisecond)
+			}
+		}()
+
+		// new jobs sort after the existing ones, so the existing ones must keep their place on every page
+		q := newListQuery().byPlan(planName).sortBy(listFieldName, true)
+		names := walkBackupJobPages(env, q, paginationTestPageSize)
+		wg.Wait()
+
+		expectNoDuplicates(names)
+		Expect(len(names)).Should(BeNumerically(">=", paginationTestJobs))
+		for i := range names {
+			Expect(names[i]).Should(Equal(paginationJobName(planName, i)), "gap or reorder at position %d", i)
+		}
+
+		jobs, err := listAllBackupJobs(env.client, env.tenant, newListQuery().byPlan(planName))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(jobs).Should(HaveLen(paginationTestJobs + paginationTestConcurrentJobs))
+	})
+})
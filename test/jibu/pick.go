@@ -0,0 +1,169 @@
+package jibu
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+
+	"github.com/antihax/optional"
+	swagger "github.com/jibutech/backup-saas-client"
+	"k8s.io/client-go/kubernetes"
+
+	. "github.com/onsi/gomega"
+)
+
+// PickMode is how one item is picked among the candidates passing the filters
+type PickMode string
+
+const (
+	// PickModeRandom picks a random candidate
+	PickModeRandom PickMode = "random"
+	// PickModeRoundRobin picks the candidate after the one picked by the previous run,
+	// so that consecutive runs cover all the candidates
+	PickModeRoundRobin PickMode = "round-robin"
+)
+
+// ClusterRole is what a picked cluster is used for, each role has its own round-robin cursor,
+// otherwise backup and restore would take turns on one cursor and always land on the same clusters
+type ClusterRole string
+
+const (
+	// ClusterRoleBackup is the cluster the namespace is backed up from
+	ClusterRoleBackup ClusterRole = "backup"
+	// ClusterRoleRestore is the cluster the backup is restored to
+	ClusterRoleRestore ClusterRole = "restore"
+)
+
+// pickState is persisted between runs for round-robin picking, it maps a key like {tenant}/cluster/backup to the last picked name
+type pickState map[string]string
+
+func loadPickState(path string) (pickState, error) {
+	state := pickState{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("invalid pick state file %s: %v", path, err)
+	}
+	return state, nil
+}
+
+func (s pickState) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// pickName picks one of the sorted candidate names according to mode and returns its index,
+// key identifies the kind of item in the state file
+func pickName(names []string, mode PickMode, statePath string, key string) (int, error) {
+	if len(names) == 0 {
+		return 0, fmt.Errorf("no candidate to pick for %s", key)
+	}
+	if mode != PickModeRoundRobin {
+		return rand.Intn(len(names)), nil
+	}
+
+	state, err := loadPickState(statePath)
+	if err != nil {
+		return 0, err
+	}
+	// the first name after the last picked one, candidates may have changed since the previous run
+	i := sort.SearchStrings(names, state[key])
+	if i < len(names) && names[i] == state[key] {
+		i++
+	}
+	i %= len(names)
+	state[key] = names[i]
+	return i, state.save(statePath)
+}
+
+func pickOneCluster(jibuClient *swagger.APIClient, tenant string, role ClusterRole, cluster string, filter *clusterFilter, mode PickMode, stateFile string) *swagger.V1alpha1Cluster {
+	if cluster != "" {
+		opts := swagger.ClusterApiGetClusterOpts{IncludeKubeconfig: optional.NewString("false")}
+		c, _, err := jibuClient.ClusterApi.GetCluster(ctx, tenant, cluster, &opts)
+		Expect(err).ShouldNot(HaveOccurred())
+		return &c
+	}
+
+	clusterList, _, err := jibuClient.ClusterApi.ListClusters(ctx, tenant, nil)
+	Expect(err).ShouldNot(HaveOccurred())
+	Expect(clusterList.Items).ShouldNot(BeEmpty())
+	k8sClientFunc := func(cluster string) (kubernetes.Interface, error) {
+		k8sClient, _, err := newK8sClientFromCluster(jibuClient, tenant, cluster)
+		return k8sClient, err
+	}
+	candidates, err := filterClusters(clusterList.Items, filter, k8sClientFunc)
+	Expect(err).ShouldNot(HaveOccurred())
+	Expect(candidates).ShouldNot(BeEmpty(), "no cluster of tenant %s passes the cluster filters", tenant)
+	MyBy(fmt.Sprintf("%d of %d clusters pass the cluster filters", len(candidates), len(clusterList.Items)))
+
+	names := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		names = append(names, c.Metadata.Name)
+	}
+	i, err := pickName(names, mode, stateFile, tenant+"/cluster/"+string(role))
+	Expect(err).ShouldNot(HaveOccurred())
+	return &candidates[i]
+}
+
+func pickOneStorage(jibuClient *swagger.APIClient, tenant string, storage string, filter *storageFilter, mode PickMode, stateFile string) *swagger.V1alpha1Storage {
+	if storage != "" {
+		opts := swagger.StorageApiGetStorageOpts{IncludeSecrets: optional.NewString("false")}
+		s, _, err := jibuClient.StorageApi.GetStorage(ctx, tenant, storage, &opts)
+		Expect(err).ShouldNot(HaveOccurred())
+		return &s
+	}
+
+	storageList, _, err := jibuClient.StorageApi.ListStorages(ctx, tenant, nil)
+	Expect(err).ShouldNot(HaveOccurred())
+	Expect(storageList.Items).ShouldNot(BeEmpty())
+	candidates := filterStorages(storageList.Items, filter)
+	Expect(candidates).ShouldNot(BeEmpty(), "no storage of tenant %s passes the storage filters", tenant)
+	MyBy(fmt.Sprintf("%d of %d storages pass the storage filters", len(candidates), len(storageList.Items)))
+
+	names := make([]string, 0, len(candidates))
+	for _, s := range candidates {
+		names = append(names, s.Metadata.Name)
+	}
+	i, err := pickName(names, mode, stateFile, tenant+"/storage")
+	Expect(err).ShouldNot(HaveOccurred())
+	return &candidates[i]
+}
+
+func pickOneNamespace(jibuClient *swagger.APIClient, tenant string, cluster string, namespace string, filter *namespaceFilter) *swagger.V1Namespace {
+	var ns *swagger.V1Namespace
+	nsList, _, err := jibuClient.ClusterApi.GetNamespaces(ctx, tenant, cluster)
+	Expect(err).ShouldNot(HaveOccurred())
+	Expect(nsList.Items).ShouldNot(BeEmpty())
+
+	if namespace != "" {
+		for _, n := range nsList.Items {
+			if n.Metadata.Name == namespace {
+				ns = &n
+				break
+			}
+		}
+		Expect(ns).ShouldNot(BeNil())
+		return ns
+	}
+
+	var k8sClient kubernetes.Interface
+	if filter.needsContent() {
+		k8sClient, _ = getK8sClientFromCluster(jibuClient, tenant, cluster)
+	}
+	candidates, err := filterNamespaces(nsList.Items, filter, k8sClient)
+	Expect(err).ShouldNot(HaveOccurred())
+	Expect(candidates).ShouldNot(BeEmpty(), "no namespace in cluster %s passes the namespace filters", cluster)
+	MyBy(fmt.Sprintf("%d of %d namespaces pass the namespace filters", len(candidates), len(nsList.Items)))
+	return &candidates[rand.Intn(len(candidates))]
+}
@@ -0,0 +1,257 @@
+package jibu
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	swagger "github.com/jibutech/backup-saas-client"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	updateTestFrequency     = "*/2 * * * *"
+	updateTestNewFrequency  = "*/5 * * * *"
+	updateTestRetention     = 2
+	updateTestPausePeriod   = 7 * time.Minute
+	scheduleTolerance       = 30 * time.Second
+	planChangeGracePeriod   = time.Minute
+	scheduledJobWaitTimeout = 12 * time.Minute
+	updateMarkerName        = "jibutest-update-marker"
+)
+
+// listBackupJobCreationTimes returns the creation time of every job of the plan, sorted ascending
+func listBackupJobCreationTimes(jibuClient *swagger.APIClient, tenant string, planName string) []time.Time {
+	jobs, err := listAllBackupJobs(jibuClient, tenant, newListQuery().byPlan(planName))
+	Expect(err).ShouldNot(HaveOccurred())
+	times := make([]time.Time, 0, len(jobs))
+	for _, j := range jobs {
+		times = append(times, j.Metadata.CreationTimestamp)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times
+}
+
+// jobsCreatedAfter returns the creation times after t
+func jobsCreatedAfter(times []time.Time, t time.Time) []time.Time {
+	var after []time.Time
+	for _, c := range times {
+		if c.After(t) {
+			after = append(after, c)
+		}
+	}
+	return after
+}
+
+// waitJobsCreatedAfter waits for n jobs of the plan to be created after t and returns their creation times
+func waitJobsCreatedAfter(jibuClient *swagger.APIClient, tenant string, planName string, t time.Time, n int, timeout time.Duration) []time.Time {
+	var after []time.Time
+	jobsCreatedCondFunc := func() (bool, error) {
+		after = jobsCreatedAfter(listBackupJobCreationTimes(jibuClient, tenant, planName), t)
+		return len(after) >= n, nil
+	}
+	err := wait.Poll(pollInterval(), timeout, jobsCreatedCondFunc)
+	Expect(err).ShouldNot(HaveOccurred(), "%d of %d jobs of plan %s are created after %v in %v", len(after), n, planName, t, timeout)
+	return after
+}
+
+// waitFirstJobCreatedAfter waits for a job of the plan to be created after t and returns the earliest one
+func waitFirstJobCreatedAfter(jibuClient *swagger.APIClient, tenant string, planName string, t time.Time, timeout time.Duration) swagger.V1alpha1BackupJob {
+	var first *swagger.V1alpha1BackupJob
+	jobCreatedCondFunc := func() (bool, error) {
+		jobs, err := listAllBackupJobs(jibuClient, tenant, newListQuery().byPlan(planName))
+		if err != nil {
+			return false, err
+		}
+		for i := range jobs {
+			created := jobs[i].Metadata.CreationTimestamp
+			if created.After(t) && (first == nil || created.Before(first.Metadata.CreationTimestamp)) {
+				first = &jobs[i]
+			}
+		}
+		return first != nil, nil
+	}
+	err := wait.Poll(pollInterval(), timeout, jobCreatedCondFunc)
+	Expect(err).ShouldNot(HaveOccurred(), "no job of plan %s is created after %v in %v", planName, t, timeout)
+	return *first
+}
+
+// expectOnSchedule asserts every creation time falls on a tick of the cron frequency
+func expectOnSchedule(times []time.Time, frequency string) {
+	schedule, err := cron.ParseStandard(frequency)
+	Expect(err).ShouldNot(HaveOccurred())
+	for _, t := range times {
+		tick := schedule.Next(t.Add(-scheduleTolerance))
+		Expect(tick.Before(t.Add(scheduleTolerance))).Should(BeTrue(), "job created at %v is not on schedule %s, nearest tick is %v", t, frequency, tick)
+	}
+}
+
+// createRepeatedPlan creates a repeated backup plan, the caller should delete it with deleteBackupPlanAndJobs
+// so that it doesn't keep firing after the spec
+func createRepeatedPlan(env *testEnv, frequency string) string {
+	name := randomName("update")
+	plan := newBackupPlan(env.tenant, name, env.cluster.Metadata.Name, env.storage.Metadata.Name, []string{env.namespace})
+	plan.Spec.Policy.Repeat = true
+	plan.Spec.Policy.Frequency = frequency
+	_, _, err := env.client.BackupPlanTagApi.CreateBackupPlan(ctx, env.tenant, plan)
+	Expect(err).ShouldNot(HaveOccurred())
+	waitBackupPlanReady(env.client, env.tenant, name)
+	MyBy(fmt.Sprintf("repeated backup plan %s created with frequency %s", name, frequency))
+	return name
+}
+
+func deleteBackupPlanAndJobs(env *testEnv, name string) {
+	if !*argCleanUpOnEnd {
+		return
+	}
+	_, _, _ = env.client.BackupPlanTagApi.DeleteBackupPlan(ctx, env.tenant, name)
+	_ = deleteJobsOfBackupPlan(env.client, env.tenant, name)
+}
+
+// updateBackupPlan applies mutate to the live plan and waits for it to be ready again, returns the time of the update
+func updateBackupPlan(env *testEnv, name string, mutate func(p *swagger.V1alpha1BackupPlan)) time.Time {
+	plan, _, err := env.client.BackupPlanTagApi.GetBackupPlan(ctx, env.tenant, name)
+	Expect(err).ShouldNot(HaveOccurred())
+	mutate(&plan)
+	updated := time.Now()
+	_, _, err = env.client.BackupPlanTagApi.UpdateBackupPlan(ctx, env.tenant, name, plan)
+	Expect(err).ShouldNot(HaveOccurred())
+	waitBackupPlanReady(env.client, env.tenant, name)
+	return updated
+}
+
+var _ = Describe("backup plan update", func() {
+	var env *testEnv
+
+	BeforeEach(func() {
+		if !*argPlanUpdateTestEnabled {
+			Skip("backup plan update tests are disabled, enable them with -jibu-plan-update-test-enabled")
+		}
+		env = getTestEnv()
+	})
+
+	It("should apply the new frequency", func() {
+		name := createRepeatedPlan(env, updateTestFrequency)
+		defer deleteBackupPlanAndJobs(env, name)
+		created := waitJobsCreatedAfter(env.client, env.tenant, name, time.Time{}, 1, scheduledJobWaitTimeout)
+		expectOnSchedule(created, updateTestFrequency)
+
+		MyBy(fmt.Sprintf("change frequency of plan %s to %s", name, updateTestNewFrequency))
+		updated := updateBackupPlan(env, name, func(p *swagger.V1alpha1BackupPlan) {
+			p.Spec.Policy.Frequency = updateTestNewFrequency
+		})
+
+		MyBy("jobs created after the update should follow the new frequency")
+		after := waitJobsCreatedAfter(env.client, env.tenant, name, updated.Add(planChangeGracePeriod), 2, 2*scheduledJobWaitTimeout)
+		expectOnSchedule(after, updateTestNewFrequency)
+	})
+
+	It("should not create jobs while paused", func() {
+		name := createRepeatedPlan(env, updateTestFrequency)
+		defer deleteBackupPlanAndJobs(env, name)
+		waitJobsCreatedAfter(env.client, env.tenant, name, time.Time{}, 1, scheduledJobWaitTimeout)
+
+		MyBy(fmt.Sprintf("pause plan %s", name))
+		paused := updateBackupPlan(env, name, func(p *swagger.V1alpha1BackupPlan) {
+			p.Spec.Policy.Repeat = false
+		})
+		time.Sleep(updateTestPausePeriod)
+
+		MyBy(fmt.Sprintf("resume plan %s", name))
+		resumed := updateBackupPlan(env, name, func(p *swagger.V1alpha1BackupPlan) {
+			p.Spec.Policy.Repeat = true
+		})
+		times := listBackupJobCreationTimes(env.client, env.tenant, name)
+		for _, t := range jobsCreatedAfter(times, paused.Add(planChangeGracePeriod)) {
+			Expect(t.After(resumed)).Should(BeTrue(), "job created at %v while plan %s is paused from %v to %v", t, name, paused, resumed)
+		}
+
+		MyBy("jobs should be created again after resuming")
+		after := waitJobsCreatedAfter(env.client, env.tenant, name, resumed, 1, scheduledJobWaitTimeout)
+		expectOnSchedule(after, updateTestFrequency)
+	})
+
+	It("should prune jobs beyond the new retention", func() {
+		name := createRepeatedPlan(env, updateTestFrequency)
+		defer deleteBackupPlanAndJobs(env, name)
+		waitJobsCreatedAfter(env.client, env.tenant, name, time.Time{}, updateTestRetention+1, 2*scheduledJobWaitTimeout)
+
+		MyBy(fmt.Sprintf("change retention of plan %s to %d", name, updateTestRetention))
+		updated := updateBackupPlan(env, name, func(p *swagger.V1alpha1BackupPlan) {
+			p.Spec.Policy.Retention = updateTestRetention
+		})
+		after := waitJobsCreatedAfter(env.client, env.tenant, name, updated, 1, scheduledJobWaitTimeout)
+
+		MyBy(fmt.Sprintf("only the latest %d jobs should be kept", updateTestRetention))
+		latest := after[len(after)-1]
+		Eventually(func() []time.Time {
+			return listBackupJobCreationTimes(env.client, env.tenant, name)
+		}, scheduledJobWaitTimeout, 10*time.Second).Should(SatisfyAll(
+			HaveLen(updateTestRetention),
+			ContainElement(latest),
+		))
+	})
+
+	It("should back up the edited namespaces", func() {
+		name := createRepeatedPlan(env, updateTestFrequency)
+		defer deleteBackupPlanAndJobs(env, name)
+		waitJobsCreatedAfter(env.client, env.tenant, name, time.Time{}, 1, scheduledJobWaitTimeout)
+
+		namespace := randomName("update-ns")
+		MyBy(fmt.Sprintf("create namespace %s", namespace))
+		k8sClient, dynamicClient := getK8sClientFromCluster(env.client, env.tenant, env.cluster.Metadata.Name)
+		_, err := k8sClient.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: namespace}}, v1.CreateOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		deferCleanup(func() {
+			_ = deleteNamespace(k8sClient, dynamicClient, namespace, true)
+		})
+		marker := &corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{Name: updateMarkerName},
+			Data:       map[string]string{"namespace": namespace},
+		}
+		_, err = k8sClient.CoreV1().ConfigMaps(namespace).Create(ctx, marker, v1.CreateOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		MyBy(fmt.Sprintf("change namespaces of plan %s to %s", name, namespace))
+		updated := updateBackupPlan(env, name, func(p *swagger.V1alpha1BackupPlan) {
+			p.Spec.Namespaces = []string{namespace}
+		})
+		plan, _, err := env.client.BackupPlanTagApi.GetBackupPlan(ctx, env.tenant, name)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(plan.Spec.Namespaces).Should(ConsistOf(namespace))
+
+		MyBy("jobs should keep being created with the edited namespaces")
+		job := waitFirstJobCreatedAfter(env.client, env.tenant, name, updated.Add(planChangeGracePeriod), scheduledJobWaitTimeout)
+		expectOnSchedule([]time.Time{job.Metadata.CreationTimestamp}, updateTestFrequency)
+		waitBackupJobComplete(env.client, env.tenant, job.Metadata.Name)
+
+		restorePlanName := randomName("update-restore")
+		restoreJobName := fmt.Sprintf("%s-job", restorePlanName)
+		restoreNamespace := determineDestNamespaceName(false, namespace)
+		deferCleanup(func() {
+			_, _, _ = env.client.RestoreJobTagApi.DeleteRestoreJob(ctx, env.tenant, restoreJobName)
+			_, _, _ = env.client.RestorePlanTagApi.DeleteRestorePlan(ctx, env.tenant, restorePlanName)
+			_ = deleteNamespace(k8sClient, dynamicClient, restoreNamespace, true)
+		})
+		MyBy(fmt.Sprintf("restore backup job %s into namespace %s", job.Metadata.Name, restoreNamespace))
+		restorePlan := newRestorePlan(env.tenant, restorePlanName, name, env.cluster.Metadata.Name, []string{fmt.Sprintf("%s:%s", namespace, restoreNamespace)})
+		_, _, err = env.client.RestorePlanTagApi.CreateRestorePlan(ctx, env.tenant, restorePlan)
+		Expect(err).ShouldNot(HaveOccurred())
+		waitRestorePlanReady(env.client, env.tenant, restorePlanName)
+		_, _, err = env.client.RestoreJobTagApi.CreateRestoreJob(ctx, env.tenant, newRestoreJob(env.tenant, restoreJobName, restorePlanName, job.Metadata.Name))
+		Expect(err).ShouldNot(HaveOccurred())
+		waitRestoreJobComplete(env.client, env.tenant, restoreJobName)
+
+		MyBy(fmt.Sprintf("marker %s of the edited namespace should be restored", updateMarkerName))
+		restored, err := k8sClient.CoreV1().ConfigMaps(restoreNamespace).Get(ctx, updateMarkerName, v1.GetOptions{})
+		Expect(err).ShouldNot(HaveOccurred(), "backup job %s created after the update doesn't hold namespace %s", job.Metadata.Name, namespace)
+		Expect(restored.Data).Should(HaveKeyWithValue("namespace", namespace))
+	})
+})
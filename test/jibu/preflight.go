@@ -0,0 +1,186 @@
+package jibu
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	swagger "github.com/jibutech/backup-saas-client"
+)
+
+// snapshotCRDs are required by the snapshot copy method
+var snapshotCRDs = []string{
+	"volumesnapshotclasses.snapshot.storage.k8s.io",
+	"volumesnapshotcontents.snapshot.storage.k8s.io",
+	"volumesnapshots.snapshot.storage.k8s.io",
+}
+
+var volumeSnapshotClassGVRs = []schema.GroupVersionResource{
+	{Group: "snapshot.storage.k8s.io", Version: "v1", Resource: "volumesnapshotclasses"},
+	{Group: "snapshot.storage.k8s.io", Version: "v1beta1", Resource: "volumesnapshotclasses"},
+}
+
+type preflightResult struct {
+	check   string
+	target  string
+	passed  bool
+	message string
+}
+
+// preflightReport is the result of all the checks done before any plan is created
+type preflightReport []preflightResult
+
+func (r *preflightReport) add(check string, target string, err error) {
+	result := preflightResult{check: check, target: target, passed: err == nil, message: "ok"}
+	if err != nil {
+		result.message = err.Error()
+	}
+	*r = append(*r, result)
+}
+
+func (r preflightReport) passed() bool {
+	for _, result := range r {
+		if !result.passed {
+			return false
+		}
+	}
+	return true
+}
+
+func (r preflightReport) String() string {
+	buf := &bytes.Buffer{}
+	w := tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tTARGET\tRESULT\tMESSAGE")
+	for _, result := range r {
+		status := "PASS"
+		if !result.passed {
+			status = "FAIL"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", result.check, result.target, status, result.message)
+	}
+	_ = w.Flush()
+	return buf.String()
+}
+
+// preflightOptions tells what to check, cluster and storage are skipped if not set
+type preflightOptions struct {
+	tenant               string
+	clusters             []string
+	storage              *swagger.V1alpha1Storage
+	copyMethod           string
+	controllerNamespaces []string
+	requiredCRDs         []string
+}
+
+// runAPIPreflight checks the api endpoint and the tenant, it's run before anything is picked through the api
+func runAPIPreflight(jibuClient *swagger.APIClient, endpoint string, tenant string) preflightReport {
+	var report preflightReport
+
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	resp, err := httpClient.Get(endpoint)
+	if err == nil {
+		_ = resp.Body.Close()
+	}
+	report.add("api endpoint reachable", endpoint, err)
+
+	// the api answers an unknown tenant with an empty list, and the suite can't run without a cluster anyway
+	clusterList, _, err := jibuClient.ClusterApi.ListClusters(ctx, tenant, nil)
+	if err == nil && len(clusterList.Items) == 0 {
+		err = fmt.Errorf("no cluster found, the tenant may not exist or has no cluster added")
+	}
+	report.add("tenant exists with clusters", tenant, err)
+
+	return report
+}
+
+// runPreflight checks the picked clusters and storage are healthy, every check is run even if a previous one fails
+func runPreflight(jibuClient *swagger.APIClient, opts preflightOptions) preflightReport {
+	var report preflightReport
+	var err error
+
+	for _, cluster := range opts.clusters {
+		kubeClient, dynamicClient, err := newK8sClientFromCluster(jibuClient, opts.tenant, cluster)
+		if err == nil {
+			_, err = kubeClient.Discovery().ServerVersion()
+		}
+		report.add("kubeconfig works", cluster, err)
+		if err != nil {
+			continue
+		}
+
+		for _, ns := range opts.controllerNamespaces {
+			report.add("controller pods running", cluster+"/"+ns, checkPodsRunning(kubeClient, ns))
+		}
+
+		crds := append([]string{}, opts.requiredCRDs...)
+		if opts.copyMethod == string(BackupCopyMethodSnapshot) {
+			crds = append(crds, snapshotCRDs...)
+		}
+		for _, crd := range crds {
+			_, err = dynamicClient.Resource(crdGVR).Get(ctx, crd, v1.GetOptions{})
+			report.add("crd exists", cluster+"/"+crd, err)
+		}
+		if opts.copyMethod == string(BackupCopyMethodSnapshot) {
+			report.add("volumesnapshotclass exists", cluster, checkVolumeSnapshotClass(dynamicClient))
+		}
+	}
+
+	if opts.storage != nil {
+		err = nil
+		if opts.storage.Status.Phase != string(PhaseReady) {
+			err = fmt.Errorf("phase is %s", opts.storage.Status.Phase)
+		}
+		report.add("storage ready", opts.storage.Metadata.Name, err)
+	}
+
+	return report
+}
+
+func checkPodsRunning(kubeClient kubernetes.Interface, namespace string) error {
+	pods, err := kubeClient.CoreV1().Pods(namespace).List(ctx, v1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	if len(pods.Items) == 0 {
+		return fmt.Errorf("no pod found")
+	}
+	var notRunning []string
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning && pod.Status.Phase != corev1.PodSucceeded {
+			notRunning = append(notRunning, fmt.Sprintf("%s(%s)", pod.Name, pod.Status.Phase))
+		}
+	}
+	if len(notRunning) != 0 {
+		return fmt.Errorf("pods not running: %s", strings.Join(notRunning, ", "))
+	}
+	return nil
+}
+
+func checkVolumeSnapshotClass(dynamicClient dynamic.Interface) error {
+	var lastErr error
+	for _, gvr := range volumeSnapshotClassGVRs {
+		list, err := dynamicClient.Resource(gvr).List(ctx, v1.ListOptions{})
+		if err != nil {
+			if errors.IsNotFound(err) {
+				lastErr = err
+				continue
+			}
+			return err
+		}
+		if len(list.Items) == 0 {
+			return fmt.Errorf("no volumesnapshotclass found")
+		}
+		return nil
+	}
+	return lastErr
+}
@@ -0,0 +1,127 @@
+package jibu
+
+import (
+	"fmt"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const annProvisionedBy = "pv.kubernetes.io/provisioned-by"
+
+// verifyRestoredPVCs compares the PVCs in the backup namespace with their restored counterparts.
+// When PVs are backed up, every bound source PVC must have a bound restored PVC with the same capacity and access modes.
+// When PVs are excluded, no restored PVC may be populated from the backup.
+func verifyRestoredPVCs(srcClient kubernetes.Interface, srcNamespace string, dstClient kubernetes.Interface, dstNamespace string,
+	withPV bool, excludedBehavior ExcludedPVBehavior) error {
+	srcPVCs, err := srcClient.CoreV1().PersistentVolumeClaims(srcNamespace).List(ctx, v1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	for _, src := range srcPVCs.Items {
+		if src.Status.Phase != corev1.ClaimBound {
+			continue
+		}
+		if withPV {
+			err = verifyRestoredPVC(dstClient, dstNamespace, &src)
+		} else {
+			err = verifyExcludedPVC(dstClient, dstNamespace, &src, excludedBehavior)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func verifyRestoredPVC(dstClient kubernetes.Interface, dstNamespace string, src *corev1.PersistentVolumeClaim) error {
+	var dst *corev1.PersistentVolumeClaim
+	pvcBoundCondFunc := func() (bool, error) {
+		pvc, err := dstClient.CoreV1().PersistentVolumeClaims(dstNamespace).Get(ctx, src.Name, v1.GetOptions{})
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		dst = pvc
+		return pvc.Status.Phase == corev1.ClaimBound, nil
+	}
+	if err := wait.Poll(pollInterval(), pvcBoundTimeout, pvcBoundCondFunc); err != nil {
+		if dst == nil {
+			return fmt.Errorf("pvc %s is not restored in namespace %s: %v", src.Name, dstNamespace, err)
+		}
+		return fmt.Errorf("restored pvc %s/%s is %s, expected %s: %v", dstNamespace, src.Name, dst.Status.Phase, corev1.ClaimBound, err)
+	}
+
+	srcCapacity := src.Status.Capacity[corev1.ResourceStorage]
+	dstCapacity := dst.Status.Capacity[corev1.ResourceStorage]
+	if srcCapacity.Cmp(dstCapacity) != 0 {
+		return fmt.Errorf("restored pvc %s/%s has capacity %s, expected %s", dstNamespace, src.Name, dstCapacity.String(), srcCapacity.String())
+	}
+	if !reflect.DeepEqual(src.Status.AccessModes, dst.Status.AccessModes) {
+		return fmt.Errorf("restored pvc %s/%s has access modes %v, expected %v", dstNamespace, src.Name, dst.Status.AccessModes, src.Status.AccessModes)
+	}
+	return nil
+}
+
+// verifyExcludedPVC checks a restored PVC is absent, or bound to a volume provisioned for it which doesn't carry
+// the backed up data. The content is only known for the PVCs of the generation fixture, whose replicas report
+// the generation found on their volumes at startup.
+func verifyExcludedPVC(dstClient kubernetes.Interface, dstNamespace string, src *corev1.PersistentVolumeClaim, behavior ExcludedPVBehavior) error {
+	dst, err := dstClient.CoreV1().PersistentVolumeClaims(dstNamespace).Get(ctx, src.Name, v1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) && behavior != ExcludedPVBehaviorEmpty {
+			return nil
+		}
+		return fmt.Errorf("failed to get restored pvc %s/%s: %v", dstNamespace, src.Name, err)
+	}
+	if behavior == ExcludedPVBehaviorAbsent {
+		return fmt.Errorf("pvc %s/%s is restored while PVs are excluded", dstNamespace, src.Name)
+	}
+
+	if dst.Spec.DataSource != nil {
+		return fmt.Errorf("restored pvc %s/%s is populated from %s %s while PVs are excluded", dstNamespace, src.Name, dst.Spec.DataSource.Kind, dst.Spec.DataSource.Name)
+	}
+	pvcBoundCondFunc := func() (bool, error) {
+		dst, err = dstClient.CoreV1().PersistentVolumeClaims(dstNamespace).Get(ctx, src.Name, v1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return dst.Status.Phase == corev1.ClaimBound, nil
+	}
+	if err = wait.Poll(pollInterval(), pvcBoundTimeout, pvcBoundCondFunc); err != nil {
+		return fmt.Errorf("restored pvc %s/%s is %s, expected %s: %v", dstNamespace, src.Name, dst.Status.Phase, corev1.ClaimBound, err)
+	}
+
+	pv, err := dstClient.CoreV1().PersistentVolumes().Get(ctx, dst.Spec.VolumeName, v1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get pv %s of restored pvc %s/%s: %v", dst.Spec.VolumeName, dstNamespace, src.Name, err)
+	}
+	if pv.Name == src.Spec.VolumeName {
+		return fmt.Errorf("restored pvc %s/%s is bound to the source pv %s while PVs are excluded", dstNamespace, src.Name, pv.Name)
+	}
+	// both timestamps are set by the api server of the restore cluster, a restored pv would be created ahead of its pvc
+	if _, ok := pv.Annotations[annProvisionedBy]; !ok || pv.CreationTimestamp.Time.Before(dst.CreationTimestamp.Time) {
+		return fmt.Errorf("pv %s of restored pvc %s/%s is not provisioned for it", pv.Name, dstNamespace, src.Name)
+	}
+
+	if replica, ok := generationFixtureReplica(dst.Name); ok {
+		if err = waitGenerationFixtureReady(dstClient, dstNamespace); err != nil {
+			return fmt.Errorf("generation fixture is not ready in namespace %s: %v", dstNamespace, err)
+		}
+		lines, err := generationFixtureLogs(dstClient, dstNamespace, replica, restoredGenerationPrefix)
+		if err != nil {
+			return err
+		}
+		if len(lines) == 0 || lines[0] != "" {
+			return fmt.Errorf("volume of restored pvc %s/%s is not empty, found generation %v while PVs are excluded", dstNamespace, src.Name, lines)
+		}
+	}
+	return nil
+}
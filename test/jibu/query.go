@@ -0,0 +1,226 @@
+package jibu
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/antihax/optional"
+
+	swagger "github.com/jibutech/backup-saas-client"
+)
+
+const (
+	// listPageSize is the number of items the list helpers ask for in one call
+	listPageSize = 100
+	// listMaxPages bounds the pages the list helpers follow, in case the server ignores page and limit
+	listMaxPages = 1000
+)
+
+// listField is a field the list endpoints filter or sort by
+type listField string
+
+// The fields supported by the jibu list endpoints. The lists carry no update time and no owner,
+// so FieldUpdateTime, FieldLastUpdateTimestamp and FieldOwnerKind can't be checked and aren't offered.
+const (
+	listFieldName              listField = FieldName
+	listFieldCreationTimestamp listField = FieldCreationTimeStamp
+	listFieldCreateTime        listField = FieldCreateTime
+	listFieldStatus            listField = FieldStatus
+	listFieldLabel             listField = FieldLabel
+)
+
+// sortableListFields are the fields sortBy accepts
+var sortableListFields = []listField{listFieldName, listFieldCreationTimestamp, listFieldCreateTime, listFieldStatus}
+
+// listQuery builds the options of the list endpoints, the zero value lists everything in the server's default order.
+// It's passed by value, so a query can be derived from another without changing it, e.g. q.page(2, 10).
+type listQuery struct {
+	filters   map[listField]string
+	planName  string
+	sortField listField
+	ascending bool
+	pageNum   int
+	limit     int
+}
+
+// newListQuery returns a query listing everything
+func newListQuery() listQuery {
+	return listQuery{}
+}
+
+// filter keeps the items whose field matches value, the filters map is copied so that q is left unchanged
+func (q listQuery) filter(field listField, value string) listQuery {
+	filters := make(map[listField]string, len(q.filters)+1)
+	for f, v := range q.filters {
+		filters[f] = v
+	}
+	filters[field] = value
+	q.filters = filters
+	return q
+}
+
+// byName keeps the items with exactly this name
+func (q listQuery) byName(name string) listQuery {
+	return q.filter(listFieldName, name)
+}
+
+// byPlan keeps the jobs of this plan, only supported by the job list endpoints
+func (q listQuery) byPlan(planName string) listQuery {
+	q.planName = planName
+	return q
+}
+
+// byStatus keeps the items in this phase
+func (q listQuery) byStatus(phase PhaseType) listQuery {
+	return q.filter(listFieldStatus, string(phase))
+}
+
+// byLabel keeps the items matching this label selector, e.g. app=foo
+func (q listQuery) byLabel(selector string) listQuery {
+	return q.filter(listFieldLabel, selector)
+}
+
+// sortBy sorts the items by one of the sortableListFields, e.g. listFieldCreationTimestamp
+func (q listQuery) sortBy(field listField, ascending bool) listQuery {
+	q.sortField = field
+	q.ascending = ascending
+	return q
+}
+
+// page returns the page-th page of limit items, pages start from 1
+func (q listQuery) page(page int, limit int) listQuery {
+	q.pageNum = page
+	q.limit = limit
+	return q
+}
+
+func optionalString(s string) optional.String {
+	if s == "" {
+		return optional.EmptyString()
+	}
+	return optional.NewString(s)
+}
+
+func optionalInt(i int) optional.String {
+	if i <= 0 {
+		return optional.EmptyString()
+	}
+	return optional.NewString(strconv.Itoa(i))
+}
+
+func (q listQuery) optionalFilter(field listField) optional.String {
+	return optionalString(q.filters[field])
+}
+
+func (q listQuery) optionalAscending() optional.String {
+	if q.sortField == "" {
+		return optional.EmptyString()
+	}
+	return optional.NewString(strconv.FormatBool(q.ascending))
+}
+
+func (q listQuery) backupJobOpts() *swagger.BackupJobTagApiListBackupJobsOpts {
+	return &swagger.BackupJobTagApiListBackupJobsOpts{
+		Name:      q.optionalFilter(listFieldName),
+		PlanName:  optionalString(q.planName),
+		Status:    q.optionalFilter(listFieldStatus),
+		Label:     q.optionalFilter(listFieldLabel),
+		SortBy:    optionalString(string(q.sortField)),
+		Ascending: q.optionalAscending(),
+		Page:      optionalInt(q.pageNum),
+		Limit:     optionalInt(q.limit),
+	}
+}
+
+func (q listQuery) restoreJobOpts() *swagger.RestoreJobTagApiListRestoreJobsOpts {
+	return &swagger.RestoreJobTagApiListRestoreJobsOpts{
+		Name:      q.optionalFilter(listFieldName),
+		PlanName:  optionalString(q.planName),
+		Status:    q.optionalFilter(listFieldStatus),
+		Label:     q.optionalFilter(listFieldLabel),
+		SortBy:    optionalString(string(q.sortField)),
+		Ascending: q.optionalAscending(),
+		Page:      optionalInt(q.pageNum),
+		Limit:     optionalInt(q.limit),
+	}
+}
+
+func (q listQuery) backupPlanOpts() *swagger.BackupPlanTagApiListBackupPlansOpts {
+	return &swagger.BackupPlanTagApiListBackupPlansOpts{
+		Name:      q.optionalFilter(listFieldName),
+		Status:    q.optionalFilter(listFieldStatus),
+		Label:     q.optionalFilter(listFieldLabel),
+		SortBy:    optionalString(string(q.sortField)),
+		Ascending: q.optionalAscending(),
+		Page:      optionalInt(q.pageNum),
+		Limit:     optionalInt(q.limit),
+	}
+}
+
+func (q listQuery) restorePlanOpts() *swagger.RestorePlanTagApiListRestorePlansOpts {
+	return &swagger.RestorePlanTagApiListRestorePlansOpts{
+		Name:      q.optionalFilter(listFieldName),
+		Status:    q.optionalFilter(listFieldStatus),
+		Label:     q.optionalFilter(listFieldLabel),
+		SortBy:    optionalString(string(q.sortField)),
+		Ascending: q.optionalAscending(),
+		Page:      optionalInt(q.pageNum),
+		Limit:     optionalInt(q.limit),
+	}
+}
+
+func (q listQuery) clusterOpts() *swagger.ClusterApiListClustersOpts {
+	return &swagger.ClusterApiListClustersOpts{
+		Name:      q.optionalFilter(listFieldName),
+		Status:    q.optionalFilter(listFieldStatus),
+		Label:     q.optionalFilter(listFieldLabel),
+		SortBy:    optionalString(string(q.sortField)),
+		Ascending: q.optionalAscending(),
+		Page:      optionalInt(q.pageNum),
+		Limit:     optionalInt(q.limit),
+	}
+}
+
+func (q listQuery) storageOpts() *swagger.StorageApiListStoragesOpts {
+	return &swagger.StorageApiListStoragesOpts{
+		Name:      q.optionalFilter(listFieldName),
+		Status:    q.optionalFilter(listFieldStatus),
+		Label:     q.optionalFilter(listFieldLabel),
+		SortBy:    optionalString(string(q.sortField)),
+		Ascending: q.optionalAscending(),
+		Page:      optionalInt(q.pageNum),
+		Limit:     optionalInt(q.limit),
+	}
+}
+
+// listAllBackupJobs follows the pages of the query until a short page or TotalItems jobs, so that every job is returned
+// however many there are. Jobs are sorted by name unless the query is sorted, as paging needs a stable order.
+// A page without new jobs or more than listMaxPages pages mean the server doesn't page, which is an error.
+func listAllBackupJobs(jibuClient *swagger.APIClient, tenant string, q listQuery) ([]swagger.V1alpha1BackupJob, error) {
+	if q.sortField == "" {
+		q = q.sortBy(listFieldName, true)
+	}
+	var jobs []swagger.V1alpha1BackupJob
+	seen := make(map[string]bool)
+	for page := 1; page <= listMaxPages; page++ {
+		jobList, _, err := jibuClient.BackupJobTagApi.ListBackupJobs(ctx, tenant, q.page(page, listPageSize).backupJobOpts())
+		if err != nil {
+			return nil, err
+		}
+		added := 0
+		for _, j := range jobList.Items {
+			if !seen[j.Metadata.Name] {
+				seen[j.Metadata.Name] = true
+				jobs = append(jobs, j)
+				added++
+			}
+		}
+		if len(jobList.Items) < listPageSize || (jobList.TotalItems > 0 && len(jobs) >= int(jobList.TotalItems)) {
+			return jobs, nil
+		}
+		if added == 0 {
+			return nil, fmt.Errorf("page %d of backup jobs has no new job, the server may ignore page and limit", page)
+		}
+	}
+	return nil, fmt.Errorf("more than %d pages of %d backup jobs, the server may ignore page and limit", listMaxPages, listPageSize)
+}
@@ -0,0 +1,284 @@
+package jibu
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	queryTestJobs     = 3
+	queryTestPlans    = 3
+	queryTestRestores = 2
+	queryTestLabelKey = "jibutest.io/query"
+	// queryTestFixtureLabelKey labels every backup plan, restore plan and restore job of the fixture
+	queryTestFixtureLabelKey = "jibutest.io/query-fixture"
+)
+
+// queryFixture is a few labeled backup plans, the first one has a few completed jobs and only its first job
+// carries the query label, and a few labeled restore plans with one completed restore job each.
+// If the tenant has an unreachable storage, a labeled plan with a failed job is added, so that not all the
+// listed backup jobs are in the same phase. They are created once and deleted after the suite.
+type queryFixture struct {
+	planName      string
+	jobNames      []string
+	failedJobName string
+	label         string
+	fixtureLabel  string
+	// size is the number of fixture items of each kind
+	size map[string]int
+}
+
+// listedItem is what the sorting checks need from an item of any list
+type listedItem struct {
+	name    string
+	created time.Time
+	phase   string
+}
+
+var query *queryFixture
+
+func getQueryFixture(env *testEnv) *queryFixture {
+	if query != nil {
+		return query
+	}
+
+	f := &queryFixture{
+		planName: randomName("query"),
+		size: map[string]int{
+			kindBackupJob:   queryTestJobs,
+			kindBackupPlan:  queryTestPlans,
+			kindRestorePlan: queryTestRestores,
+			kindRestoreJob:  queryTestRestores,
+		},
+	}
+	f.label = fmt.Sprintf("%s=%s", queryTestLabelKey, f.planName)
+	f.fixtureLabel = fmt.Sprintf("%s=%s", queryTestFixtureLabelKey, f.planName)
+	MyBy(fmt.Sprintf("create backup plan %s with %d jobs for the list query tests", f.planName, queryTestJobs))
+	createQueryBackupPlan(env, f, f.planName)
+
+	for i := 0; i < queryTestJobs; i++ {
+		// job names are in the reverse order of creation, so that sorting by name and by creation time differ
+		name := fmt.Sprintf("%s-%d", f.planName, queryTestJobs-i)
+		job := newBackupJob(env.tenant, name, f.planName)
+		job.Metadata.Labels = map[string]string{queryTestFixtureLabelKey: f.planName}
+		if i == 0 {
+			job.Metadata.Labels[queryTestLabelKey] = f.planName
+		}
+		_, _, err := env.client.BackupJobTagApi.CreateBackupJob(ctx, env.tenant, job)
+		Expect(err).ShouldNot(HaveOccurred())
+		waitBackupJobComplete(env.client, env.tenant, name)
+		f.jobNames = append(f.jobNames, name)
+		// creation timestamps are in seconds
+		time.Sleep(time.Second)
+	}
+
+	// the other plans are named in the reverse order of creation too
+	for i := 1; i < queryTestPlans; i++ {
+		createQueryBackupPlan(env, f, fmt.Sprintf("%s-plan-%d", f.planName, queryTestPlans-i))
+		time.Sleep(time.Second)
+	}
+	for i := 0; i < queryTestRestores; i++ {
+		createQueryRestore(env, f, fmt.Sprintf("%s-restore-%d", f.planName, queryTestRestores-i))
+		time.Sleep(time.Second)
+	}
+	if findUnreachableStorage(env) != "" {
+		MyBy("create a failed backup job for the status queries")
+		_, f.failedJobName = createFailedBackupJob(env, map[string]string{queryTestFixtureLabelKey: f.planName})
+		f.size[kindBackupJob]++
+		f.size[kindBackupPlan]++
+	}
+
+	query = f
+	return query
+}
+
+func createQueryBackupPlan(env *testEnv, f *queryFixture, name string) {
+	plan := newBackupPlan(env.tenant, name, env.cluster.Metadata.Name, env.storage.Metadata.Name, []string{env.namespace})
+	plan.Metadata.Labels = map[string]string{queryTestFixtureLabelKey: f.planName}
+	_, _, err := env.client.BackupPlanTagApi.CreateBackupPlan(ctx, env.tenant, plan)
+	Expect(err).ShouldNot(HaveOccurred())
+	deferCleanup(func() {
+		_, _, _ = env.client.BackupPlanTagApi.DeleteBackupPlan(ctx, env.tenant, name)
+		_ = deleteJobsOfBackupPlan(env.client, env.tenant, name)
+	})
+	waitBackupPlanReady(env.client, env.tenant, name)
+}
+
+// createQueryRestore restores the first job of the fixture into a new namespace with a labeled plan and job
+func createQueryRestore(env *testEnv, f *queryFixture, restorePlanName string) {
+	restoreJobName := fmt.Sprintf("%s-job", restorePlanName)
+	restoreNamespace := determineDestNamespaceName(false, env.namespace)
+	deferCleanup(func() {
+		_, _, _ = env.client.RestoreJobTagApi.DeleteRestoreJob(ctx, env.tenant, restoreJobName)
+		_, _, _ = env.client.RestorePlanTagApi.DeleteRestorePlan(ctx, env.tenant, restorePlanName)
+		k8sClient, dynamicClient, err := newK8sClientFromCluster(env.client, env.tenant, env.cluster.Metadata.Name)
+		if err == nil {
+			_ = deleteNamespace(k8sClient, dynamicClient, restoreNamespace, true)
+		}
+	})
+
+	mapping := fmt.Sprintf("%s:%s", env.namespace, restoreNamespace)
+	restorePlan := newRestorePlan(env.tenant, restorePlanName, f.planName, env.cluster.Metadata.Name, []string{mapping})
+	restorePlan.Metadata.Labels = map[string]string{queryTestFixtureLabelKey: f.planName}
+	_, _, err := env.client.RestorePlanTagApi.CreateRestorePlan(ctx, env.tenant, restorePlan)
+	Expect(err).ShouldNot(HaveOccurred())
+	waitRestorePlanReady(env.client, env.tenant, restorePlanName)
+
+	restoreJob := newRestoreJob(env.tenant, restoreJobName, restorePlanName, f.jobNames[0])
+	restoreJob.Metadata.Labels = map[string]string{queryTestFixtureLabelKey: f.planName}
+	_, _, err = env.client.RestoreJobTagApi.CreateRestoreJob(ctx, env.tenant, restoreJob)
+	Expect(err).ShouldNot(HaveOccurred())
+	waitRestoreJobComplete(env.client, env.tenant, restoreJobName)
+}
+
+func listBackupJobNames(env *testEnv, q listQuery) []string {
+	jobList, _, err := env.client.BackupJobTagApi.ListBackupJobs(ctx, env.tenant, q.backupJobOpts())
+	Expect(err).ShouldNot(HaveOccurred())
+	names := make([]string, 0, len(jobList.Items))
+	for _, j := range jobList.Items {
+		names = append(names, j.Metadata.Name)
+	}
+	return names
+}
+
+// listQueryItems lists the labeled fixture items of one kind
+func listQueryItems(env *testEnv, f *queryFixture, kind string, q listQuery) []listedItem {
+	var items []listedItem
+	switch kind {
+	case kindBackupJob:
+		list, _, err := env.client.BackupJobTagApi.ListBackupJobs(ctx, env.tenant, q.byLabel(f.fixtureLabel).backupJobOpts())
+		Expect(err).ShouldNot(HaveOccurred())
+		for _, i := range list.Items {
+			items = append(items, listedItem{i.Metadata.Name, i.Metadata.CreationTimestamp, i.Status.Phase})
+		}
+	case kindBackupPlan:
+		list, _, err := env.client.BackupPlanTagApi.ListBackupPlans(ctx, env.tenant, q.byLabel(f.fixtureLabel).backupPlanOpts())
+		Expect(err).ShouldNot(HaveOccurred())
+		for _, i := range list.Items {
+			items = append(items, listedItem{i.Metadata.Name, i.Metadata.CreationTimestamp, i.Status.Phase})
+		}
+	case kindRestorePlan:
+		list, _, err := env.client.RestorePlanTagApi.ListRestorePlans(ctx, env.tenant, q.byLabel(f.fixtureLabel).restorePlanOpts())
+		Expect(err).ShouldNot(HaveOccurred())
+		for _, i := range list.Items {
+			items = append(items, listedItem{i.Metadata.Name, i.Metadata.CreationTimestamp, i.Status.Phase})
+		}
+	case kindRestoreJob:
+		list, _, err := env.client.RestoreJobTagApi.ListRestoreJobs(ctx, env.tenant, q.byLabel(f.fixtureLabel).restoreJobOpts())
+		Expect(err).ShouldNot(HaveOccurred())
+		for _, i := range list.Items {
+			items = append(items, listedItem{i.Metadata.Name, i.Metadata.CreationTimestamp, i.Status.Phase})
+		}
+	default:
+		Fail(fmt.Sprintf("unknown kind %s", kind))
+	}
+	return items
+}
+
+// listFieldLess orders the listed items the way the server should for each sortable field
+var listFieldLess = map[listField]func(a, b *listedItem) bool{
+	listFieldName:              func(a, b *listedItem) bool { return a.name < b.name },
+	listFieldCreationTimestamp: func(a, b *listedItem) bool { return a.created.Before(b.created) },
+	listFieldCreateTime:        func(a, b *listedItem) bool { return a.created.Before(b.created) },
+	listFieldStatus:            func(a, b *listedItem) bool { return a.phase < b.phase },
+}
+
+// sortEntries is an entry for every kind, sortable field and direction
+func sortEntries() []TableEntry {
+	var entries []TableEntry
+	for _, kind := range []string{kindBackupJob, kindBackupPlan, kindRestorePlan, kindRestoreJob} {
+		for _, field := range sortableListFields {
+			for _, ascending := range []bool{true, false} {
+				description := fmt.Sprintf("%ss by %s, ascending %v", kind, field, ascending)
+				entries = append(entries, Entry(description, kind, field, ascending))
+			}
+		}
+	}
+	return entries
+}
+
+var _ = Describe("list query conformance", func() {
+	var env *testEnv
+	var fixture *queryFixture
+
+	BeforeEach(func() {
+		if !*argQueryTestEnabled {
+			Skip("list query conformance tests are disabled, enable them with -jibu-query-test-enabled")
+		}
+		env = getTestEnv()
+		fixture = getQueryFixture(env)
+	})
+
+	DescribeTable("sorting should be honored",
+		func(kind string, field listField, ascending bool) {
+			items := listQueryItems(env, fixture, kind, newListQuery().sortBy(field, ascending))
+			Expect(items).Should(HaveLen(fixture.size[kind]))
+			if field == listFieldStatus {
+				phases := map[string]bool{}
+				for _, i := range items {
+					phases[i.phase] = true
+				}
+				if len(phases) < 2 {
+					Skip(fmt.Sprintf("all the listed %ss are in the same phase, set -jibu-unreachable-storage to add a failed backup job", kind))
+				}
+			}
+			less := listFieldLess[field]
+			for i := 1; i < len(items); i++ {
+				prev, next := &items[i-1], &items[i]
+				if !ascending {
+					prev, next = next, prev
+				}
+				Expect(less(next, prev)).Should(BeFalse(), "%s is listed before %s sorting %ss by %s, ascending %v",
+					items[i-1].name, items[i].name, kind, field, ascending)
+			}
+		},
+		sortEntries()...,
+	)
+
+	It("should filter backup jobs by name", func() {
+		name := fixture.jobNames[1]
+		Expect(listBackupJobNames(env, newListQuery().byName(name))).Should(Equal([]string{name}))
+	})
+
+	It("should filter backup jobs by status", func() {
+		if fixture.failedJobName == "" {
+			Skip("no failed backup job to filter by status, set -jibu-unreachable-storage to add one")
+		}
+		completed := listBackupJobNames(env, newListQuery().byLabel(fixture.fixtureLabel).byStatus(JobPhaseCompleted))
+		Expect(completed).Should(ConsistOf(fixture.jobNames))
+		failed := listBackupJobNames(env, newListQuery().byLabel(fixture.fixtureLabel).byStatus(JobPhaseFailed))
+		Expect(failed).Should(Equal([]string{fixture.failedJobName}))
+	})
+
+	It("should filter backup jobs by label", func() {
+		labeled := listBackupJobNames(env, newListQuery().byPlan(fixture.planName).byLabel(fixture.label))
+		Expect(labeled).Should(Equal([]string{fixture.jobNames[0]}))
+	})
+
+	It("should page backup jobs", func() {
+		q := newListQuery().byPlan(fixture.planName).sortBy(listFieldCreationTimestamp, true)
+		var paged []string
+		for page := 1; page <= queryTestJobs; page++ {
+			names := listBackupJobNames(env, q.page(page, 1))
+			Expect(names).Should(HaveLen(1), "page %d of size 1", page)
+			paged = append(paged, names...)
+		}
+		Expect(paged).Should(Equal(fixture.jobNames))
+		Expect(listBackupJobNames(env, q.page(queryTestJobs+1, 1))).Should(BeEmpty())
+	})
+
+	It("should filter backup plans by name and label", func() {
+		planList, _, err := env.client.BackupPlanTagApi.ListBackupPlans(ctx, env.tenant, newListQuery().byName(fixture.planName).backupPlanOpts())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(planList.Items).Should(HaveLen(1))
+		Expect(planList.Items[0].Metadata.Name).Should(Equal(fixture.planName))
+
+		planList, _, err = env.client.BackupPlanTagApi.ListBackupPlans(ctx, env.tenant, newListQuery().byLabel(fixture.label).backupPlanOpts())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(planList.Items).Should(BeEmpty(), "the plan isn't labeled, only its first job is")
+	})
+})
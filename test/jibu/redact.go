@@ -0,0 +1,207 @@
+package jibu
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+const redactedMask = "******"
+
+// sensitiveFieldKeywords mark the fields whose values are masked, matched case-insensitively against the
+// trailing words of json keys and flag names, e.g. kubeconfig, secretKey, client-key-data, but not
+// jibu-api-kubeconfig-allowed or jibu-cluster-kubeconfigs, whose values are a bool and paths
+var sensitiveFieldKeywords = []string{
+	"kubeconfig",
+	"password",
+	"passwd",
+	"secret",
+	"secretkey",
+	"token",
+	"accesskey",
+	"accesskeyid",
+	"credential",
+	"credentials",
+	"authorization",
+	"apikey",
+	"privatekey",
+	"clientkeydata",
+	"clientcertificatedata",
+	"certificateauthoritydata",
+}
+
+// sensitiveTextPatterns find credentials in free text, the first group is kept and the rest of the match is masked
+var sensitiveTextPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(authorization["']?\s*[:=]\s*["']?)[^\s"',}]+(\s+[^\s"',}]+)?`),
+	regexp.MustCompile(`(?i)(bearer\s+)[a-z0-9._~+/=-]+`),
+	regexp.MustCompile(`(?i)((?:client-key|client-certificate|certificate-authority)-data["']?\s*:\s*["']?)[^\s"',}]+`),
+	regexp.MustCompile(`(?i)((?:password|passwd|token|secret|access[-_]?key|secret[-_]?key|kubeconfig)["']?\s*[:=]\s*["']?)[^\s"',}]+`),
+}
+
+var customPatterns struct {
+	sync.Once
+	patterns []*regexp.Regexp
+	err      error
+}
+
+// parseRedactPatterns compiles -jibu-redact-patterns, the whole match of each of them is masked
+func parseRedactPatterns() ([]*regexp.Regexp, error) {
+	customPatterns.Do(func() {
+		for _, p := range *argRedactPatterns {
+			if p == "" {
+				continue
+			}
+			re, err := regexp.Compile(p)
+			if err != nil {
+				customPatterns.err = fmt.Errorf("invalid redact pattern %s: %v", p, err)
+				return
+			}
+			customPatterns.patterns = append(customPatterns.patterns, re)
+		}
+	})
+	return customPatterns.patterns, customPatterns.err
+}
+
+// fieldWords splits a json key or flag name into lower case words at -, _, . and camel case boundaries
+func fieldWords(name string) []string {
+	var words []string
+	var word []rune
+	var prev rune
+	for _, r := range name {
+		switch {
+		case r == '-' || r == '_' || r == '.':
+			r = 0
+		case unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)):
+			words = append(words, string(word))
+			word = nil
+		}
+		if r == 0 {
+			if len(word) != 0 {
+				words = append(words, string(word))
+			}
+			word = nil
+		} else {
+			word = append(word, unicode.ToLower(r))
+		}
+		prev = r
+	}
+	if len(word) != 0 {
+		words = append(words, string(word))
+	}
+	return words
+}
+
+// isSensitiveField tells if the value of the field or flag should be masked, that is its last words make a keyword
+func isSensitiveField(name string) bool {
+	words := fieldWords(name)
+	suffix := ""
+	for i := len(words) - 1; i >= 0; i-- {
+		suffix = words[i] + suffix
+		for _, keyword := range sensitiveFieldKeywords {
+			if suffix == keyword {
+				return true
+			}
+		}
+	}
+	for _, extra := range splitList(*argRedactFields) {
+		if strings.EqualFold(name, extra) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactText masks the credentials found in the text by the built-in and the configured patterns
+func redactText(text string) string {
+	for _, re := range sensitiveTextPatterns {
+		text = re.ReplaceAllString(text, "${1}"+redactedMask)
+	}
+	patterns, _ := parseRedactPatterns()
+	for _, re := range patterns {
+		text = re.ReplaceAllString(text, redactedMask)
+	}
+	return text
+}
+
+// redactCopy returns a copy of v of the same type with the sensitive fields masked, string fields are set
+// to the mask and the others are cleared. v is returned as is if it can't be copied through json.
+func redactCopy(v interface{}) interface{} {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var generic interface{}
+	if err = json.Unmarshal(raw, &generic); err != nil {
+		return v
+	}
+	if raw, err = json.Marshal(redactValue(generic)); err != nil {
+		return v
+	}
+
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return v
+	}
+	ptr := t.Kind() == reflect.Ptr
+	if ptr {
+		t = t.Elem()
+	}
+	copied := reflect.New(t)
+	if err = json.Unmarshal(raw, copied.Interface()); err != nil {
+		return v
+	}
+	if ptr {
+		return copied.Interface()
+	}
+	return copied.Elem().Interface()
+}
+
+func redactValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, item := range value {
+			if !isSensitiveField(k) {
+				value[k] = redactValue(item)
+				continue
+			}
+			if _, ok := item.(string); ok {
+				value[k] = redactedMask
+			} else {
+				value[k] = nil
+			}
+		}
+		return value
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redactValue(item)
+		}
+		return value
+	case string:
+		return redactText(value)
+	default:
+		return v
+	}
+}
+
+// redactedDump is spew.Sdump of the redacted copies of the values
+func redactedDump(values ...interface{}) string {
+	redacted := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		redacted = append(redacted, redactCopy(v))
+	}
+	return redactText(spew.Sdump(redacted...))
+}
+
+// redactedFlagValue returns the value of the flag to print, masked if the flag is sensitive
+func redactedFlagValue(name string, value string) string {
+	if value != "" && isSensitiveField(name) {
+		return redactedMask
+	}
+	return redactText(value)
+}
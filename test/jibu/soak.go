@@ -0,0 +1,266 @@
+package jibu
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	swagger "github.com/jibutech/backup-saas-client"
+)
+
+var podMetricsGVR = schema.GroupVersionResource{Group: "metrics.k8s.io", Version: "v1beta1", Resource: "pods"}
+
+// errNoRestorePoint is returned when the soak plan has no completed backup job to restore from yet
+var errNoRestorePoint = errors.New("no completed backup job to restore from")
+
+// soakStallFactor times the longest gap of the schedule without a new backup job means the schedule has stalled
+const soakStallFactor = 2
+
+// maxScheduleGap returns the longest gap between the next ticks of the cron frequency
+func maxScheduleGap(frequency string, ticks int) (time.Duration, error) {
+	schedule, err := cron.ParseStandard(frequency)
+	if err != nil {
+		return 0, err
+	}
+	var gap time.Duration
+	t := schedule.Next(time.Now())
+	for i := 0; i < ticks; i++ {
+		next := schedule.Next(t)
+		if next.Sub(t) > gap {
+			gap = next.Sub(t)
+		}
+		t = next
+	}
+	return gap, nil
+}
+
+// latestJobCreation returns the creation time of the newest job, or since if there is no newer one
+func latestJobCreation(jobs []swagger.V1alpha1BackupJob, since time.Time) time.Time {
+	latest := since
+	for _, j := range jobs {
+		if j.Metadata.CreationTimestamp.After(latest) {
+			latest = j.Metadata.CreationTimestamp
+		}
+	}
+	return latest
+}
+
+// podUsage tracks the resource usage of a controller pod during the soak test
+type podUsage struct {
+	FirstMemory string `json:"firstMemory"`
+	LastMemory  string `json:"lastMemory"`
+	MaxMemory   string `json:"maxMemory"`
+	LastCPU     string `json:"lastCPU"`
+	MaxCPU      string `json:"maxCPU"`
+	Restarts    int32  `json:"restarts"`
+
+	maxMemory resource.Quantity
+	maxCPU    resource.Quantity
+}
+
+func (u *podUsage) sample(cpu resource.Quantity, memory resource.Quantity) {
+	if u.FirstMemory == "" {
+		u.FirstMemory = memory.String()
+	}
+	u.LastMemory = memory.String()
+	u.LastCPU = cpu.String()
+	if memory.Cmp(u.maxMemory) > 0 {
+		u.maxMemory = memory
+		u.MaxMemory = memory.String()
+	}
+	if cpu.Cmp(u.maxCPU) > 0 {
+		u.maxCPU = cpu
+		u.MaxCPU = cpu.String()
+	}
+}
+
+// soakSummary is the rolling summary of a soak test, written to a file after every round
+type soakSummary struct {
+	mu sync.Mutex
+
+	Started           time.Time            `json:"started"`
+	Updated           time.Time            `json:"updated"`
+	Elapsed           string               `json:"elapsed"`
+	BackupPlan        string               `json:"backupPlan"`
+	BackupJobs        int                  `json:"backupJobs"`
+	BackupsCompleted  int                  `json:"backupsCompleted"`
+	BackupsFailed     int                  `json:"backupsFailed"`
+	RestoresSucceeded int                  `json:"restoresSucceeded"`
+	RestoresFailed    int                  `json:"restoresFailed"`
+	ErrorBudget       int                  `json:"errorBudget"`
+	Errors            []string             `json:"errors"`
+	Controllers       map[string]*podUsage `json:"controllers"`
+	// PhaseChanges counts the phase events by {kind} {phase}
+	PhaseChanges map[string]int `json:"phaseChanges"`
+	// Failures counts the errors by failure type, e.g. BackupJobTimeout or JobFailed
+	Failures map[string]int `json:"failures"`
+}
+
+func newSoakSummary(backupPlan string, errorBudget int) *soakSummary {
+	now := time.Now()
+	return &soakSummary{
+		Started:      now,
+		Updated:      now,
+		BackupPlan:   backupPlan,
+		ErrorBudget:  errorBudget,
+		Controllers:  map[string]*podUsage{},
+		PhaseChanges: map[string]int{},
+		Failures:     map[string]int{},
+	}
+}
+
+// countPhaseEvent is a phaseEventHandler counting the phase changes, progress only changes are not counted
+func (s *soakSummary) countPhaseEvent(e phaseEvent) {
+	if e.Phase == e.PreviousPhase {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.PhaseChanges[e.Kind+" "+e.Phase]++
+}
+
+func (s *soakSummary) addError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Errors = append(s.Errors, fmt.Sprintf("%s %s", time.Now().Format(time.RFC3339), redactText(err.Error())))
+	if failure := failureTypeOf(err); failure != "" {
+		s.Failures[string(failure)]++
+	}
+}
+
+// errorCount counts the recorded errors and the failed backup jobs
+func (s *soakSummary) errorCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.Errors) + s.BackupsFailed
+}
+
+func (s *soakSummary) budgetExceeded() bool {
+	return s.errorCount() > s.ErrorBudget
+}
+
+func (s *soakSummary) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fmt.Sprintf("soak elapsed %s: backup jobs %d(completed %d, failed %d), restores succeeded %d, failed %d, errors %d/%d",
+		s.Elapsed, s.BackupJobs, s.BackupsCompleted, s.BackupsFailed, s.RestoresSucceeded, s.RestoresFailed, len(s.Errors)+s.BackupsFailed, s.ErrorBudget)
+}
+
+func (s *soakSummary) save(path string) error {
+	s.mu.Lock()
+	s.Updated = time.Now()
+	s.Elapsed = s.Updated.Sub(s.Started).Round(time.Second).String()
+	data, err := json.MarshalIndent(s, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// countBackupJobs updates the job counters from the jobs of the plan
+func (s *soakSummary) countBackupJobs(jobPhases []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.BackupJobs = len(jobPhases)
+	s.BackupsCompleted, s.BackupsFailed = 0, 0
+	for _, phase := range jobPhases {
+		switch phase {
+		case string(JobPhaseCompleted):
+			s.BackupsCompleted++
+		case string(JobPhaseFailed):
+			s.BackupsFailed++
+		}
+	}
+}
+
+func (s *soakSummary) countRestore(err error) {
+	if errors.Is(err, errNoRestorePoint) {
+		return
+	}
+	if err != nil {
+		s.addError(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.RestoresFailed++
+	} else {
+		s.RestoresSucceeded++
+	}
+}
+
+// sampleControllers records the memory and cpu usage and the restarts of the pods in the controller namespaces,
+// usage is read from the metrics api, which is optional, restarts are always recorded
+func (s *soakSummary) sampleControllers(kubeClient kubernetes.Interface, dynamicClient dynamic.Interface, namespaces []string) error {
+	for _, ns := range namespaces {
+		pods, err := kubeClient.CoreV1().Pods(ns).List(ctx, v1.ListOptions{})
+		if err != nil {
+			return err
+		}
+		metrics, metricsErr := dynamicClient.Resource(podMetricsGVR).Namespace(ns).List(ctx, v1.ListOptions{})
+
+		s.mu.Lock()
+		for _, pod := range pods.Items {
+			usage := s.usageOf(ns + "/" + pod.Name)
+			usage.Restarts = podRestarts(&pod)
+		}
+		if metricsErr == nil {
+			for _, m := range metrics.Items {
+				cpu, memory := podMetricsUsage(&m)
+				s.usageOf(ns+"/"+m.GetName()).sample(cpu, memory)
+			}
+		}
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+func (s *soakSummary) usageOf(pod string) *podUsage {
+	usage, ok := s.Controllers[pod]
+	if !ok {
+		usage = &podUsage{}
+		s.Controllers[pod] = usage
+	}
+	return usage
+}
+
+func podRestarts(pod *corev1.Pod) int32 {
+	var restarts int32
+	for _, status := range pod.Status.ContainerStatuses {
+		restarts += status.RestartCount
+	}
+	return restarts
+}
+
+// podMetricsUsage sums the usage of all the containers of a PodMetrics object
+func podMetricsUsage(m *unstructured.Unstructured) (resource.Quantity, resource.Quantity) {
+	var cpu, memory resource.Quantity
+	containers, _, _ := unstructured.NestedSlice(m.Object, "containers")
+	for _, c := range containers {
+		container, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		usage, _, _ := unstructured.NestedStringMap(container, "usage")
+		if q, err := resource.ParseQuantity(usage["cpu"]); err == nil {
+			cpu.Add(q)
+		}
+		if q, err := resource.ParseQuantity(usage["memory"]); err == nil {
+			memory.Add(q)
+		}
+	}
+	return cpu, memory
+}
@@ -0,0 +1,152 @@
+package jibu
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	swagger "github.com/jibutech/backup-saas-client"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// soakRestore restores a random completed job of the plan into a new namespace, verifies it and cleans it up
+func soakRestore(env *testEnv, planName string, jobs []swagger.V1alpha1BackupJob) error {
+	var completed []swagger.V1alpha1BackupJob
+	for _, j := range jobs {
+		if j.Status.Phase == string(JobPhaseCompleted) {
+			completed = append(completed, j)
+		}
+	}
+	if len(completed) == 0 {
+		return errNoRestorePoint
+	}
+	job := completed[rand.Intn(len(completed))]
+
+	restorePlanName := randomName("soak-restore")
+	restoreJobName := fmt.Sprintf("%s-job", restorePlanName)
+	restoreNamespace := determineDestNamespaceName(false, env.namespace)
+	k8sClient, dynamicClient, err := newK8sClientFromCluster(env.client, env.tenant, env.cluster.Metadata.Name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, _, _ = env.client.RestoreJobTagApi.DeleteRestoreJob(ctx, env.tenant, restoreJobName)
+		_, _, _ = env.client.RestorePlanTagApi.DeleteRestorePlan(ctx, env.tenant, restorePlanName)
+		_ = deleteNamespace(k8sClient, dynamicClient, restoreNamespace, true)
+	}()
+
+	MyBy(fmt.Sprintf("soak: restore backup job %s into namespace %s", job.Metadata.Name, restoreNamespace))
+	mapping := fmt.Sprintf("%s:%s", env.namespace, restoreNamespace)
+	restorePlan := newRestorePlan(env.tenant, restorePlanName, planName, env.cluster.Metadata.Name, []string{mapping})
+	if _, _, err = env.client.RestorePlanTagApi.CreateRestorePlan(ctx, env.tenant, restorePlan); err != nil {
+		return fmt.Errorf("failed to create restore plan %s: %v", restorePlanName, err)
+	}
+	restoreJob := newRestoreJob(env.tenant, restoreJobName, restorePlanName, job.Metadata.Name)
+	if _, _, err = env.client.RestoreJobTagApi.CreateRestoreJob(ctx, env.tenant, restoreJob); err != nil {
+		return fmt.Errorf("failed to create restore job %s: %v", restoreJobName, err)
+	}
+	if err = pollRestoreJobComplete(env.client, env.tenant, restoreJobName); err != nil {
+		return err
+	}
+	err = verifyRestoredPVCs(k8sClient, env.namespace, k8sClient, restoreNamespace, true, ExcludedPVBehaviorAny)
+	if err != nil {
+		return fmt.Errorf("restore of backup job %s is not verified: %v", job.Metadata.Name, err)
+	}
+	return nil
+}
+
+var _ = Describe("soak", func() {
+	var env *testEnv
+
+	BeforeEach(func() {
+		if *argSoakDuration <= 0 {
+			Skip("soak test is disabled, enable it with -jibu-soak-duration")
+		}
+		env = getTestEnv()
+	})
+
+	It("should keep backing up and restoring within the error budget", func() {
+		deadline := time.Now().Add(*argSoakDuration)
+		name := createRepeatedPlan(env, *argBackupFrequency)
+		defer deleteBackupPlanAndJobs(env, name)
+
+		summary := newSoakSummary(name, *argSoakErrorBudget)
+		unregister := onPhaseEvent(summary.countPhaseEvent)
+		defer unregister()
+		k8sClient, dynamicClient := getK8sClientFromCluster(env.client, env.tenant, env.cluster.Metadata.Name)
+		controllerNamespaces := splitList(*argControllerNamespaces)
+		lastRestore := time.Now()
+		gap, err := maxScheduleGap(*argBackupFrequency, 10)
+		Expect(err).ShouldNot(HaveOccurred())
+		stallTimeout := soakStallFactor*gap + planChangeGracePeriod
+		planCreated := time.Now()
+
+		// a restore takes long, it runs aside so that the sampling goes on, one at a time
+		var restoreDone chan error
+		startRestore := func(jobs []swagger.V1alpha1BackupJob) {
+			done := make(chan error, 1)
+			restoreDone = done
+			go func() {
+				err := errors.New("restore is aborted")
+				defer func() { done <- err }()
+				defer GinkgoRecover()
+				err = soakRestore(env, name, jobs)
+			}()
+		}
+		defer func() {
+			if restoreDone != nil {
+				summary.countRestore(<-restoreDone)
+			}
+		}()
+
+		MyBy(fmt.Sprintf("soak until %v, summary is written to %s", deadline.Format(time.RFC3339), *argSoakSummaryFile))
+		for time.Now().Before(deadline) {
+			select {
+			case err = <-restoreDone:
+				restoreDone = nil
+				// retry on the next sample until a job has completed
+				if !errors.Is(err, errNoRestorePoint) {
+					lastRestore = time.Now()
+				}
+				summary.countRestore(err)
+			default:
+			}
+
+			var stalled error
+			jobs, err := listAllBackupJobs(env.client, env.tenant, newListQuery().byPlan(name))
+			if err != nil {
+				summary.addError(fmt.Errorf("failed to list backup jobs: %v", err))
+			} else {
+				phases := make([]string, 0, len(jobs))
+				for _, j := range jobs {
+					observePhase(kindBackupJob, j.Metadata.Name, j.Status.Phase, j.Status.Message, j.Status)
+					phases = append(phases, j.Status.Phase)
+				}
+				summary.countBackupJobs(phases)
+
+				if latest := latestJobCreation(jobs, planCreated); time.Since(latest) > stallTimeout {
+					stalled = fmt.Errorf("no backup job of plan %s is created since %v, one is expected every %v", name, latest.Format(time.RFC3339), gap)
+					summary.addError(stalled)
+				}
+				if restoreDone == nil && time.Since(lastRestore) >= *argSoakRestoreInterval {
+					startRestore(jobs)
+				}
+			}
+
+			if err = summary.sampleControllers(k8sClient, dynamicClient, controllerNamespaces); err != nil {
+				summary.addError(fmt.Errorf("failed to sample controllers: %v", err))
+			}
+			if err = summary.save(*argSoakSummaryFile); err != nil {
+				log.warn("failed to write soak summary", "file", *argSoakSummaryFile, "error", err)
+			}
+			MyBy(summary.String())
+
+			Expect(stalled).ShouldNot(HaveOccurred(), "soak schedule stalled")
+			Expect(summary.budgetExceeded()).Should(BeFalse(), "soak error budget exceeded: %v", summary.Errors)
+			time.Sleep(soakSampleInterval)
+		}
+	})
+})
@@ -0,0 +1,126 @@
+package jibu
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	swagger "github.com/jibutech/backup-saas-client"
+)
+
+const gib = 1 << 30
+
+// measuredDataSize is the capacity in bytes of the bound pvcs of the backup namespace
+var measuredDataSize int64
+
+// failureType tells why a wait failed, so a slow run isn't confused with a failed job in the reports
+type failureType string
+
+const (
+	failureBackupPlanReadyTimeout   failureType = "BackupPlanReadyTimeout"
+	failureRestorePlanReadyTimeout  failureType = "RestorePlanReadyTimeout"
+	failureBackupJobCreationTimeout failureType = "BackupJobCreationTimeout"
+	failureBackupJobTimeout         failureType = "BackupJobTimeout"
+	failureRestoreJobTimeout        failureType = "RestoreJobTimeout"
+	failureJobFailed                failureType = "JobFailed"
+)
+
+// phaseFailure is the error of a wait which timed out or ended in an unexpected phase
+type phaseFailure struct {
+	failure failureType
+	msg     string
+}
+
+func newPhaseFailure(failure failureType, format string, args ...interface{}) *phaseFailure {
+	return &phaseFailure{failure: failure, msg: fmt.Sprintf(format, args...)}
+}
+
+func (f *phaseFailure) Error() string {
+	return fmt.Sprintf("[%s] %s", f.failure, f.msg)
+}
+
+// failureTypeOf returns the failure type of the error, empty if it's not a phaseFailure
+func failureTypeOf(err error) failureType {
+	var f *phaseFailure
+	if errors.As(err, &f) {
+		return f.failure
+	}
+	return ""
+}
+
+// pollInterval is how often the REST api and the clusters are polled while waiting
+func pollInterval() time.Duration {
+	return *argPollInterval
+}
+
+func scaleTimeout(timeout time.Duration) time.Duration {
+	return time.Duration(float64(timeout) * *argTimeoutScale)
+}
+
+// scaleJobTimeout scales the timeout and extends it by -jibu-job-timeout-per-gib for every GiB of backup data
+func scaleJobTimeout(timeout time.Duration) time.Duration {
+	size := backupDataSize()
+	return scaleTimeout(timeout) + time.Duration(float64(*argJobTimeoutPerGiB)*float64(size)/gib)
+}
+
+func backupPlanReadyTimeout() time.Duration {
+	return scaleTimeout(*argBackupPlanReadyTimeout)
+}
+
+func restorePlanReadyTimeout() time.Duration {
+	return scaleTimeout(*argRestorePlanReadyTimeout)
+}
+
+func backupJobRepeatedCreationTimeout() time.Duration {
+	return scaleTimeout(*argBackupJobCreationTimeout)
+}
+
+func backupJobFinishedTimeout() time.Duration {
+	return scaleJobTimeout(*argBackupJobTimeout)
+}
+
+func restoreJobFinishedTimeout() time.Duration {
+	return scaleJobTimeout(*argRestoreJobTimeout)
+}
+
+// backupDataSize is the size in bytes the job timeouts are scaled by, -jibu-data-size if set, otherwise the measured one
+func backupDataSize() int64 {
+	if *argDataSize != "" {
+		// validated by validateFlags
+		size := resource.MustParse(*argDataSize)
+		return size.Value()
+	}
+	return atomic.LoadInt64(&measuredDataSize)
+}
+
+// measureBackupDataSize measures the capacity of the bound pvcs in the backup namespace, only if the job timeouts
+// are scaled by the data size and it's not given by -jibu-data-size, so that the cluster isn't reached otherwise
+func measureBackupDataSize(jibuClient *swagger.APIClient, tenant string, cluster string, namespace string) error {
+	if *argDataSize != "" || *argJobTimeoutPerGiB == 0 || !*argBackupWithPV {
+		return nil
+	}
+
+	kubeClient, _, err := newK8sClientFromCluster(jibuClient, tenant, cluster)
+	if err != nil {
+		return err
+	}
+	pvcs, err := kubeClient.CoreV1().PersistentVolumeClaims(namespace).List(ctx, v1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, pvc := range pvcs.Items {
+		if pvc.Status.Phase != corev1.ClaimBound {
+			continue
+		}
+		capacity := pvc.Status.Capacity[corev1.ResourceStorage]
+		size += capacity.Value()
+	}
+	atomic.StoreInt64(&measuredDataSize, size)
+	return nil
+}
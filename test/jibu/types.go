@@ -28,6 +28,18 @@ const (
 	JobPhaseSubmitted PhaseType = "JobSubmitted"
 )
 
+// ExcludedPVBehavior is what a restore of a backup without PVs is expected to do with the PVCs
+type ExcludedPVBehavior string
+
+const (
+	// ExcludedPVBehaviorAbsent means the PVCs are not restored at all
+	ExcludedPVBehaviorAbsent ExcludedPVBehavior = "absent"
+	// ExcludedPVBehaviorEmpty means the PVCs are restored but bound to freshly provisioned empty volumes
+	ExcludedPVBehaviorEmpty ExcludedPVBehavior = "empty"
+	// ExcludedPVBehaviorAny accepts either of the above, per PVC
+	ExcludedPVBehaviorAny ExcludedPVBehavior = "any"
+)
+
 type BackupCopyMethod string
 
 const (
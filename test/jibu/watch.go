@@ -0,0 +1,315 @@
+package jibu
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+
+	swagger "github.com/jibutech/backup-saas-client"
+)
+
+const (
+	// crWatchLookupTimeout is how long a waiter waits for the resource to show up in the watched CRs before falling back to REST polling
+	crWatchLookupTimeout = 30 * time.Second
+	// crossCheckTimeout is how long the REST view may lag behind the CRD view
+	crossCheckTimeout = 30 * time.Second
+)
+
+// crResources maps the kinds of the jibu resources to the plural of their custom resources
+var crResources = map[string]string{
+	kindBackupPlan:  "backupplans",
+	kindBackupJob:   "backupjobs",
+	kindRestorePlan: "restoreplans",
+	kindRestoreJob:  "restorejobs",
+}
+
+var errNotWatched = errors.New("not found in the watched custom resources")
+
+// crWatcher keeps the phases of the jibu custom resources in one cluster up to date by watching them.
+// It's a plain list and watch loop, as the informers of this client-go don't build against the apimachinery in use.
+type crWatcher struct {
+	cluster       string
+	dynamicClient dynamic.Interface
+	mu            sync.Mutex
+	// phases is keyed by {kind}/{name}
+	phases map[string]string
+}
+
+// crWatch holds the watchers of all the clusters, changed is closed and replaced on every update seen by any of them.
+// waited holds the waiters of each {kind}/{name}, only their updates are fed into the phase events.
+var crWatch = struct {
+	sync.Mutex
+	watchers map[string]*crWatcher
+	changed  chan struct{}
+	waited   map[string]*crWaiters
+}{
+	watchers: make(map[string]*crWatcher),
+	changed:  make(chan struct{}),
+	waited:   make(map[string]*crWaiters),
+}
+
+// crWaiters counts the waiters of a custom resource, scenario is the spec of the first one,
+// the watch goroutines tag the events of the resource with it
+type crWaiters struct {
+	count    int
+	scenario string
+}
+
+// registerCRWaiter marks the custom resource as waited for, the returned function unregisters it
+func registerCRWaiter(kind string, name string) func() {
+	key := kind + "/" + name
+	crWatch.Lock()
+	defer crWatch.Unlock()
+	waiters, ok := crWatch.waited[key]
+	if !ok {
+		waiters = &crWaiters{scenario: currentScenario()}
+		crWatch.waited[key] = waiters
+	}
+	waiters.count++
+	return func() {
+		crWatch.Lock()
+		defer crWatch.Unlock()
+		if waiters.count--; waiters.count <= 0 {
+			delete(crWatch.waited, key)
+		}
+	}
+}
+
+func crGVR(kind string) schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: *argCRGroup, Version: *argCRVersion, Resource: crResources[kind]}
+}
+
+// startCRWatch starts watching the jibu custom resources in the cluster if -jibu-watch-crs is set,
+// the waiters keep polling the REST api if the CRDs aren't reachable
+func startCRWatch(jibuClient *swagger.APIClient, tenant string, cluster string) {
+	if !*argWatchCRs {
+		return
+	}
+	crWatch.Lock()
+	_, started := crWatch.watchers[cluster]
+	crWatch.Unlock()
+	if started {
+		return
+	}
+
+	w, err := newCRWatcher(jibuClient, tenant, cluster)
+	if err != nil {
+		log.with(logKeyCluster, cluster).warn("can't watch jibu custom resources, keep polling the REST api", "error", err)
+		return
+	}
+	crWatch.Lock()
+	crWatch.watchers[cluster] = w
+	crWatch.Unlock()
+	log.with(logKeyCluster, cluster, logKeyNamespace, *argCRNamespace).info("watching jibu custom resources")
+}
+
+// newCRWatcher lists every jibu custom resource once, then keeps watching them for as long as the test process lives
+func newCRWatcher(jibuClient *swagger.APIClient, tenant string, cluster string) (*crWatcher, error) {
+	_, dynamicClient, err := newK8sClientFromCluster(jibuClient, tenant, cluster)
+	if err != nil {
+		return nil, err
+	}
+	for kind := range crResources {
+		gvr := crGVR(kind)
+		if _, err = dynamicClient.Resource(crdGVR).Get(ctx, gvr.Resource+"."+gvr.Group, v1.GetOptions{}); err != nil {
+			return nil, err
+		}
+	}
+
+	w := &crWatcher{cluster: cluster, dynamicClient: dynamicClient, phases: make(map[string]string)}
+	for kind := range crResources {
+		resourceVersion, err := w.list(kind)
+		if err != nil {
+			return nil, err
+		}
+		go w.watch(kind, resourceVersion)
+	}
+	return w, nil
+}
+
+// list fills the phases of the kind, returns the resource version to watch from
+func (w *crWatcher) list(kind string) (string, error) {
+	list, err := w.dynamicClient.Resource(crGVR(kind)).Namespace(*argCRNamespace).List(ctx, v1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	for i := range list.Items {
+		w.update(kind, &list.Items[i])
+	}
+	return list.GetResourceVersion(), nil
+}
+
+// watch follows the changes of the kind, it relists when the watch can't be resumed
+func (w *crWatcher) watch(kind string, resourceVersion string) {
+	ri := w.dynamicClient.Resource(crGVR(kind)).Namespace(*argCRNamespace)
+	for {
+		watcher, err := ri.Watch(ctx, v1.ListOptions{ResourceVersion: resourceVersion, AllowWatchBookmarks: true})
+		if err != nil {
+			time.Sleep(5 * time.Second)
+			if resourceVersion, err = w.list(kind); err != nil {
+				resourceVersion = ""
+			}
+			continue
+		}
+		for event := range watcher.ResultChan() {
+			if event.Type == watch.Error {
+				// mostly the resource version is too old, start over
+				resourceVersion = ""
+				break
+			}
+			u, ok := event.Object.(*unstructured.Unstructured)
+			if !ok {
+				continue
+			}
+			resourceVersion = u.GetResourceVersion()
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				w.update(kind, u)
+			case watch.Deleted:
+				w.mu.Lock()
+				delete(w.phases, kind+"/"+u.GetName())
+				w.mu.Unlock()
+			}
+		}
+		watcher.Stop()
+		if resourceVersion == "" {
+			if resourceVersion, err = w.list(kind); err != nil {
+				time.Sleep(5 * time.Second)
+			}
+		}
+	}
+}
+
+// update records the phase of the custom resource and wakes up the waiters, the update is fed into the phase events
+// only if the resource is waited for, so that the resources of other runs don't flood them
+func (w *crWatcher) update(kind string, u *unstructured.Unstructured) {
+	key := kind + "/" + u.GetName()
+	phase, _, _ := unstructured.NestedString(u.Object, "status", "phase")
+	w.mu.Lock()
+	w.phases[key] = phase
+	w.mu.Unlock()
+
+	crWatch.Lock()
+	waiters, waited := crWatch.waited[key]
+	var scenario string
+	if waited {
+		scenario = waiters.scenario
+	}
+	close(crWatch.changed)
+	crWatch.changed = make(chan struct{})
+	crWatch.Unlock()
+
+	if waited {
+		status, _, _ := unstructured.NestedMap(u.Object, "status")
+		message, _, _ := unstructured.NestedString(u.Object, "status", "message")
+		observeScenarioPhase(scenario, kind, u.GetName(), phase, message, status)
+	}
+}
+
+// crPhase returns the phase of the custom resource, found is false if it isn't watched
+func (w *crWatcher) crPhase(kind string, name string) (phase string, found bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	phase, found = w.phases[kind+"/"+name]
+	return phase, found
+}
+
+// activeCRWatchers returns the started watchers, nil if the CRs are not watched
+func activeCRWatchers() []*crWatcher {
+	crWatch.Lock()
+	defer crWatch.Unlock()
+	watchers := make([]*crWatcher, 0, len(crWatch.watchers))
+	for _, w := range crWatch.watchers {
+		watchers = append(watchers, w)
+	}
+	return watchers
+}
+
+// waitCRPhase waits for the custom resource to reach a phase accepted by done, it returns errNotWatched
+// if the resource doesn't show up in any watched cluster in crWatchLookupTimeout
+func waitCRPhase(watchers []*crWatcher, kind string, name string, done func(phase string) bool, timeout time.Duration) (string, error) {
+	unregister := registerCRWaiter(kind, name)
+	defer unregister()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	lookup := time.NewTimer(crWatchLookupTimeout)
+	defer lookup.Stop()
+	lookupExpired := lookup.C
+
+	for {
+		// the channel is taken before reading the caches, so that no update in between is missed
+		crWatch.Lock()
+		changed := crWatch.changed
+		crWatch.Unlock()
+
+		for _, w := range watchers {
+			if phase, found := w.crPhase(kind, name); found {
+				if done(phase) {
+					return phase, nil
+				}
+				lookupExpired = nil
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-lookupExpired:
+			return "", errNotWatched
+		case <-deadline.C:
+			return "", wait.ErrWaitTimeout
+		}
+	}
+}
+
+// waitPhase waits until getPhase returns a phase accepted by done. getPhase reads the REST api, it's polled
+// every 5 seconds unless the custom resources are watched. When they are, the transitions are picked up from the
+// watch and the REST view is cross-checked against the CRD view at the end. Either way it returns
+// wait.ErrWaitTimeout on timeout, after which getPhase has been called at least once.
+func waitPhase(kind string, name string, timeout time.Duration, done func(phase string) bool, getPhase func() (string, error)) error {
+	restCondFunc := func() (bool, error) {
+		phase, err := getPhase()
+		if err != nil {
+			return false, err
+		}
+		return done(phase), nil
+	}
+
+	watchers := activeCRWatchers()
+	if len(watchers) == 0 {
+		return wait.Poll(pollInterval(), timeout, restCondFunc)
+	}
+
+	started := time.Now()
+	crPhase, err := waitCRPhase(watchers, kind, name, done, timeout)
+	if err == errNotWatched {
+		resourceLogger(kind, name).warn("poll the REST api instead", "error", err)
+		return wait.Poll(pollInterval(), timeout-time.Since(started), restCondFunc)
+	}
+	if err != nil {
+		_, _ = getPhase()
+		return err
+	}
+
+	var restPhase string
+	crossCheckCondFunc := func() (bool, error) {
+		phase, err := getPhase()
+		if err != nil {
+			return false, err
+		}
+		restPhase = phase
+		return restPhase == crPhase, nil
+	}
+	err = wait.Poll(time.Second, crossCheckTimeout, crossCheckCondFunc)
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("%s %s is %s in the CRD view, but %s in the REST view after %v", kind, name, crPhase, restPhase, crossCheckTimeout)
+	}
+	return err
+}
@@ -0,0 +1,273 @@
+package jibu
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/restmapper"
+)
+
+// kindApplyOrder lists the kinds that must exist before the objects referring to them,
+// kinds not listed here, e.g. custom resources, are applied last
+var kindApplyOrder = []string{
+	"CustomResourceDefinition",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"ServiceAccount",
+	"Role",
+	"RoleBinding",
+	"Secret",
+	"ConfigMap",
+	"PersistentVolumeClaim",
+	"Service",
+	"Deployment",
+	"StatefulSet",
+}
+
+var crdGVR = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+
+// workloadGVRs are the resources of the seeded workloads checked after restore
+var workloadGVRs = map[string]schema.GroupVersionResource{
+	"Deployment":  {Group: "apps", Version: "v1", Resource: "deployments"},
+	"StatefulSet": {Group: "apps", Version: "v1", Resource: "statefulsets"},
+}
+
+// workloadRefs returns the workloads among the objects as {kind}/{name}
+func workloadRefs(objs []*unstructured.Unstructured) []string {
+	var refs []string
+	for _, obj := range objs {
+		if _, ok := workloadGVRs[obj.GetKind()]; ok {
+			refs = append(refs, obj.GetKind()+"/"+obj.GetName())
+		}
+	}
+	return refs
+}
+
+// loadManifests reads all the yaml/json files in dir, each file may contain multiple documents
+func loadManifests(dir string) ([]*unstructured.Unstructured, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var objs []*unstructured.Unstructured
+	for _, f := range files {
+		ext := strings.ToLower(filepath.Ext(f.Name()))
+		if f.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+		for {
+			obj := &unstructured.Unstructured{}
+			if err = decoder.Decode(&obj.Object); err != nil {
+				if err == io.EOF {
+					break
+				}
+				return nil, fmt.Errorf("failed to decode %s: %v", f.Name(), err)
+			}
+			// skip empty documents
+			if len(obj.Object) == 0 {
+				continue
+			}
+			objs = append(objs, obj)
+		}
+	}
+
+	sort.SliceStable(objs, func(i, j int) bool {
+		return kindOrder(objs[i].GetKind()) < kindOrder(objs[j].GetKind())
+	})
+	return objs, nil
+}
+
+func kindOrder(kind string) int {
+	for i, k := range kindApplyOrder {
+		if k == kind {
+			return i
+		}
+	}
+	return len(kindApplyOrder)
+}
+
+// createNamespace creates a namespace owned by the suite, it fails if the namespace already exists,
+// so that a namespace of the user is never seeded and deleted afterwards
+func createNamespace(kubeClient kubernetes.Interface, namespace string) error {
+	ns := &corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: namespace}}
+	_, err := kubeClient.CoreV1().Namespaces().Create(ctx, ns, v1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		return fmt.Errorf("namespace %s already exists, only a new namespace is seeded", namespace)
+	}
+	return err
+}
+
+// seedWorkload applies the manifests in dir into namespace, which must have been created by the suite,
+// then waits for the workloads to become ready. The cluster-scoped objects it creates, e.g. CRDs, are deleted
+// with the other cleanups at the end of the suite.
+func seedWorkload(kubeClient kubernetes.Interface, dynamicClient dynamic.Interface, dir string, namespace string) error {
+	objs, err := loadManifests(dir)
+	if err != nil {
+		return err
+	}
+	if len(objs) == 0 {
+		return fmt.Errorf("no manifests found in %s", dir)
+	}
+
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(kubeClient.Discovery()))
+	for _, obj := range objs {
+		// custom resources can only be mapped after their CRDs are established
+		if kindOrder(obj.GetKind()) == len(kindApplyOrder) {
+			mapper.Reset()
+		}
+		if err = applyObject(dynamicClient, mapper, obj, namespace); err != nil {
+			return fmt.Errorf("failed to apply %s %s: %v", obj.GetKind(), obj.GetName(), err)
+		}
+		if obj.GetKind() == "CustomResourceDefinition" {
+			if err = waitCRDEstablished(dynamicClient, obj.GetName()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return waitWorkloadReady(kubeClient, namespace)
+}
+
+func applyObject(dynamicClient dynamic.Interface, mapper meta.RESTMapper, obj *unstructured.Unstructured, namespace string) error {
+	gvk := obj.GroupVersionKind()
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return err
+	}
+
+	var ri dynamic.ResourceInterface
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		obj.SetNamespace(namespace)
+		setSubjectNamespace(obj, namespace)
+		ri = dynamicClient.Resource(mapping.Resource).Namespace(namespace)
+	} else {
+		ri = dynamicClient.Resource(mapping.Resource)
+	}
+
+	_, err = ri.Create(ctx, obj, v1.CreateOptions{})
+	if err == nil && mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		name := obj.GetName()
+		deferCleanup(func() {
+			_ = ri.Delete(ctx, name, v1.DeleteOptions{})
+		})
+	}
+	if err == nil || !errors.IsAlreadyExists(err) {
+		return err
+	}
+	existing, err := ri.Get(ctx, obj.GetName(), v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	obj.SetResourceVersion(existing.GetResourceVersion())
+	_, err = ri.Update(ctx, obj, v1.UpdateOptions{})
+	return err
+}
+
+// setSubjectNamespace points service account subjects without namespace to the seeded namespace,
+// so that the manifests don't have to know where they will be applied
+func setSubjectNamespace(obj *unstructured.Unstructured, namespace string) {
+	if obj.GetKind() != "RoleBinding" && obj.GetKind() != "ClusterRoleBinding" {
+		return
+	}
+	subjects, found, err := unstructured.NestedSlice(obj.Object, "subjects")
+	if err != nil || !found {
+		return
+	}
+	for _, s := range subjects {
+		subject, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if subject["kind"] == "ServiceAccount" && subject["namespace"] == nil {
+			subject["namespace"] = namespace
+		}
+	}
+	_ = unstructured.SetNestedSlice(obj.Object, subjects, "subjects")
+}
+
+func waitCRDEstablished(dynamicClient dynamic.Interface, name string) error {
+	crdEstablishedCondFunc := func() (bool, error) {
+		crd, err := dynamicClient.Resource(crdGVR).Get(ctx, name, v1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		conditions, _, _ := unstructured.NestedSlice(crd.Object, "status", "conditions")
+		for _, c := range conditions {
+			cond, ok := c.(map[string]interface{})
+			if ok && cond["type"] == "Established" && cond["status"] == "True" {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return wait.Poll(pollInterval(), time.Minute, crdEstablishedCondFunc)
+}
+
+// waitWorkloadReady waits for all the deployments and statefulsets in namespace to be ready
+// and all the pvcs to be bound
+func waitWorkloadReady(kubeClient kubernetes.Interface, namespace string) error {
+	workloadReadyCondFunc := func() (bool, error) {
+		deployments, err := kubeClient.AppsV1().Deployments(namespace).List(ctx, v1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, d := range deployments.Items {
+			if d.Status.ObservedGeneration < d.Generation || d.Spec.Replicas == nil || d.Status.ReadyReplicas != *d.Spec.Replicas {
+				return false, nil
+			}
+		}
+		statefulSets, err := kubeClient.AppsV1().StatefulSets(namespace).List(ctx, v1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, s := range statefulSets.Items {
+			if s.Status.ObservedGeneration < s.Generation || s.Spec.Replicas == nil || s.Status.ReadyReplicas != *s.Spec.Replicas {
+				return false, nil
+			}
+		}
+		pvcs, err := kubeClient.CoreV1().PersistentVolumeClaims(namespace).List(ctx, v1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, pvc := range pvcs.Items {
+			if pvc.Status.Phase != corev1.ClaimBound {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+	err := wait.Poll(pollInterval(), workloadReadyTimeout, workloadReadyCondFunc)
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("workload in namespace %s is not ready in %v", namespace, workloadReadyTimeout)
+	}
+	return err
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}